@@ -16,6 +16,8 @@ import (
 	"github.com/wader/fq/pkg/scalar"
 )
 
+var luajitGroup decode.Group
+
 func init() {
 	interp.RegisterFormat(
 		format.ELF,
@@ -23,6 +25,9 @@ func init() {
 			Description: "Executable and Linkable Format",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    elfDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.LuaJIT}, Out: &luajitGroup},
+			},
 		})
 }
 
@@ -626,6 +631,8 @@ func elfReadDynamicTags(d *decode.D, ec *elfContext) dynamicContext {
 type symbol struct {
 	name  uint64
 	value uint64
+	size  uint64
+	shndx uint64
 }
 
 func elfReadSymbolTable(d *decode.D, ec *elfContext, sh sectionHeader) []symbol {
@@ -634,27 +641,29 @@ func elfReadSymbolTable(d *decode.D, ec *elfContext, sh sectionHeader) []symbol
 	for i := 0; i < int(sh.size/sh.entSize); i++ {
 		var name uint64
 		var value uint64
+		var size uint64
+		var shndx uint64
 		switch ec.archBits {
 		case 32:
 			name = d.U32()  // name
 			value = d.U32() // value
-			d.U32()         // size
+			size = d.U32()  // size
 			d.U4()          // bind
 			d.U4()          // type
 			d.U6()          // other_unused
 			d.U2()          // visibility
-			d.U16()         // shndx
+			shndx = d.U16() // shndx
 		case 64:
 			name = d.U32()  // name
 			d.U4()          // bind
 			d.U4()          // type
 			d.U6()          // other_unused
 			d.U2()          // visibility
-			d.U16()         // shndx
+			shndx = d.U16() // shndx
 			value = d.U64() // value
-			d.U64()         // size
+			size = d.U64()  // size
 		}
-		ss = append(ss, symbol{name: name, value: value})
+		ss = append(ss, symbol{name: name, value: value, size: size, shndx: shndx})
 	}
 
 	return ss
@@ -669,6 +678,7 @@ type sectionHeader struct {
 	typ     int
 	dc      dynamicContext // if SHT_DYNAMIC
 	symbols []symbol
+	luajit  bool // section starts with a luaJIT_BC_<name> bytecode dump
 }
 
 const maxStrTabSize = 100_000_000
@@ -755,6 +765,19 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 			ec.strTabMap[strIndexNull(sh.name, shStrTab)] = readStrTab(d, sh.offset, sh.size/8)
 		}
 	}
+
+	// luajit -b -t o puts the bytecode dump in its own section with a
+	// luaJIT_BC_<name> symbol pointing to the start of it
+	for _, sh := range ec.sections {
+		for _, s := range sh.symbols {
+			if s.value != 0 || s.shndx >= uint64(len(ec.sections)) {
+				continue
+			}
+			if strings.HasPrefix(strIndexNull(int(s.name), ec.strTabMap[STRTAB_STRTAB]), "luaJIT_BC_") {
+				ec.sections[s.shndx].luajit = true
+			}
+		}
+	}
 }
 
 type elfContext struct {
@@ -1081,7 +1104,11 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 	case SHT_PROGBITS:
 		// TODO: name progbits?
 		// TODO: decode opcodes
-		d.FieldRawLen("data", size)
+		if sh.luajit {
+			d.FieldFormatOrRawLen("data", size, &luajitGroup, nil)
+		} else {
+			d.FieldRawLen("data", size)
+		}
 	case SHT_GNU_HASH:
 		d.FieldStruct("gnu_hash", func(d *decode.D) {
 			elfDecodeGNUHash(d, ec, size, ec.strTabMap[STRTAB_DYNSTR])
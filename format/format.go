@@ -45,6 +45,7 @@ type Probe_Args_In struct {
 
 var (
 	Image          = &decode.Group{Name: "image"}
+	Lua            = &decode.Group{Name: "lua"} // compiled lua, ex: luajit
 	Probe          = &decode.Group{Name: "probe", DefaultInArg: Probe_In{}}
 	Probe_Args     = &decode.Group{Name: "probe_args", DefaultInArg: Probe_Args_In{}}
 	Link_Frame     = &decode.Group{Name: "link_frame", DefaultInArg: Link_Frame_In{}}   // ex: ethernet
@@ -123,7 +124,11 @@ var (
 	JPEG                = &decode.Group{Name: "jpeg"}
 	JSON                = &decode.Group{Name: "json"}
 	JSONL               = &decode.Group{Name: "jsonl"}
+	Lua52               = &decode.Group{Name: "lua52"}
+	Lua53               = &decode.Group{Name: "lua53"}
 	LuaJIT              = &decode.Group{Name: "luajit"}
+	LuaJIT_C            = &decode.Group{Name: "luajit_c"}
+	LuaJIT_GCProto      = &decode.Group{Name: "luajit_gcproto"}
 	MachO               = &decode.Group{Name: "macho"}
 	MachO_Fat           = &decode.Group{Name: "macho_fat"}
 	Markdown            = &decode.Group{Name: "markdown"}
@@ -372,6 +377,37 @@ type Bitcoin_Block_In struct {
 	HasHeader bool `doc:"Has blkdat header"`
 }
 
+type LuaJIT_In struct {
+	Strict              bool    `doc:"Fail on inconsistencies instead of annotating them"`
+	AllowUnknownVersion bool    `doc:"Decode unknown versions as the latest known version"`
+	Dialect             string  `doc:"Opcode table: luajit2.0, luajit2.1, openresty or moonjit (same as luajit2.1), default based on version"`
+	Version             float64 `doc:"Decode as version 2.0 or 2.1 regardless of the header version, 0 to use header"`
+	MaxItems            uint64  `doc:"Max number of instructions, constants or table items, 0 for no limit"`
+	HeadersOnly         bool    `doc:"Only decode dump and proto headers, skip proto bodies"`
+	DecodeDebug         bool    `doc:"Decode debug info, otherwise keep it as raw bytes"`
+	Variables           bool    `doc:"Add params, locals and upvalues per proto from debug info as variables"`
+	DecodeInstructions  bool    `doc:"Decode instructions, otherwise keep them as raw bytes per proto"`
+	Recover             bool    `doc:"Keep corrupt protos as raw data and continue with the next proto"`
+	NoHeader            bool    `doc:"Decode protos without a dump header, flags from no_header_flags"`
+	NoHeaderFlags       uint64  `doc:"Dump flags for no_header, be 1, strip 2, ffi 4 and fr2 8"`
+	WideInt             string  `doc:"64 bit cdata constants as decimal number, hex or string"`
+	FloatFormat         string  `doc:"Float constants as decimal number, hex float (C %a) or bits"`
+	ProbeTrailing       bool    `doc:"Probe data after the dump for known formats"`
+	StringDisplayMax    uint64  `doc:"Truncate displayed string constants to this many bytes, 0 for no limit"`
+	NumberModel         string  `doc:"Number model of the producing build: auto, float or dualnum"`
+	MaxPrefix           uint64  `doc:"Skip up to this many bytes before the dump signature, a first line starting with # is always skipped"`
+	Proto               int64   `doc:"Only decode the proto with this index, others are raw, -1 for all"`
+	ProtoLast           int64   `doc:"With proto decode protos from proto to this index"`
+}
+
+type LuaJIT_GCProto_In struct {
+	Base        uint64  `doc:"Address of the first input byte"`
+	Address     uint64  `doc:"Address of the GCproto, 0 for the first input byte"`
+	PointerSize uint64  `doc:"Size of GCRef and MRef, 4 or 8 for GC64 builds"`
+	Version     float64 `doc:"Opcode table of version 2.0 or 2.1"`
+	BigEndian   bool    `doc:"Big endian process"`
+}
+
 type TLS_In struct {
 	Keylog string `doc:"NSS Key Log content"`
 }
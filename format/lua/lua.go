@@ -0,0 +1,327 @@
+package lua
+
+// Lua 5.2 and 5.3 precompiled chunks as written by luac or string.dump
+//
+// chunk    = header [numupvalB] function
+// header   = ESC 'L' 'u' 'a' versionB formatB 5.2: endianB sizesB* integralB tail
+//                                             5.3: data sizesB* luacint luacnum
+// function = [5.3: source] linedefinedI lastlinedefinedI numparamsB isvarargB
+//            maxstacksizeB code constants 5.2: protos upvalues
+//                                          5.3: upvalues protos
+//            debug
+// debug    = [5.2: source] lineinfo locvars upvaluenames
+//
+// B = 8 bit, I = sizeof(int), numupval is only in 5.3
+
+// see:
+//
+//	* https://www.lua.org/source/5.2/lundump.c.html
+//	* https://www.lua.org/source/5.3/lundump.c.html
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.Lua52,
+		&decode.Format{
+			Description: "Lua 5.2 bytecode",
+			Groups:      []*decode.Group{format.Probe, format.Lua},
+			DecodeFn:    func(d *decode.D) any { return decodeLua(0x52, d) },
+		})
+	interp.RegisterFormat(
+		format.Lua53,
+		&decode.Format{
+			Description: "Lua 5.3 bytecode",
+			Groups:      []*decode.Group{format.Probe, format.Lua},
+			DecodeFn:    func(d *decode.D) any { return decodeLua(0x53, d) },
+		})
+}
+
+const (
+	luacInt = 0x5678
+	luacNum = 370.5
+)
+
+var luacTail = []byte{0x19, 0x93, '\r', '\n', 0x1a, '\n'}
+
+var endianMap = scalar.UintMapSymStr{
+	0: "big",
+	1: "little",
+}
+
+var formatMap = scalar.UintMapDescription{
+	0: "official",
+}
+
+// 5.3 has variant bits 4-5 for numbers and strings
+var constantTypeMap = scalar.UintMapSymStr{
+	0x00: "nil",
+	0x01: "boolean",
+	0x03: "number",
+	0x04: "string",
+	0x13: "integer",
+	0x14: "long_string",
+}
+
+type header struct {
+	version     uint64
+	endian      decode.Endian
+	opcodes     []opcode
+	opSyms      scalar.UintMapSymStr
+	intSize     int
+	sizeTSize   int
+	integerSize int
+	numberSize  int
+	integral    bool
+}
+
+func checkSize(d *decode.D, name string, size uint64, sizes ...uint64) int {
+	for _, s := range sizes {
+		if size == s {
+			return int(size)
+		}
+	}
+	d.Fatalf("unsupported %s size %d", name, size)
+	return 0
+}
+
+func decodeHeader52(h *header, d *decode.D) {
+	d.FieldU8("format", formatMap)
+	h.endian = decode.LittleEndian
+	if d.FieldU8("endian", endianMap) == 0 {
+		h.endian = decode.BigEndian
+	}
+	h.intSize = checkSize(d, "int", d.FieldU8("int_size"), 1, 2, 4, 8)
+	h.sizeTSize = checkSize(d, "size_t", d.FieldU8("size_t_size"), 1, 2, 4, 8)
+	checkSize(d, "instruction", d.FieldU8("instruction_size"), 4)
+	numberSize := d.FieldU8("number_size")
+	h.integral = d.FieldU8("integral", scalar.UintMapSymBool{0: false, 1: true}) != 0
+	if h.integral {
+		h.numberSize = checkSize(d, "number", numberSize, 1, 2, 4, 8)
+	} else {
+		h.numberSize = checkSize(d, "number", numberSize, 4, 8)
+	}
+	d.FieldRawLen("tail", int64(len(luacTail))*8, d.AssertBitBuf(luacTail))
+}
+
+// 5.3 has no endian byte, byte order is the one where luac_int is 0x5678
+func decodeHeader53(h *header, d *decode.D) {
+	d.FieldU8("format", formatMap)
+	d.FieldRawLen("data", int64(len(luacTail))*8, d.AssertBitBuf(luacTail))
+	h.intSize = checkSize(d, "int", d.FieldU8("int_size"), 1, 2, 4, 8)
+	h.sizeTSize = checkSize(d, "size_t", d.FieldU8("size_t_size"), 1, 2, 4, 8)
+	checkSize(d, "instruction", d.FieldU8("instruction_size"), 4)
+	h.integerSize = checkSize(d, "integer", d.FieldU8("integer_size"), 1, 2, 4, 8)
+	h.numberSize = checkSize(d, "number", d.FieldU8("number_size"), 4, 8)
+
+	h.endian = decode.BigEndian
+	if bs := d.PeekBytes(h.integerSize); bs[0] == luacInt&0xff {
+		h.endian = decode.LittleEndian
+	}
+	d.Endian = h.endian
+	d.FieldS("luac_int", h.integerSize*8, d.SintAssert(luacInt), scalar.SintHex)
+	d.FieldF("luac_num", h.numberSize*8, d.FltAssert(luacNum))
+}
+
+// NULL strings have size 0, others include the terminating zero which is not
+// part of the value
+func decodeString(h *header, d *decode.D, name string) {
+	d.FieldStruct(name, func(d *decode.D) {
+		var size uint64
+		if h.version == 0x52 {
+			size = d.FieldU("size", h.sizeTSize*8)
+		} else {
+			size = d.FieldU8("size", scalar.UintMapDescription{0xff: "size_t size follows"})
+			if size == 0xff {
+				size = d.FieldU("long_size", h.sizeTSize*8)
+			}
+		}
+		if size == 0 {
+			d.FieldValueAny("value", nil)
+			return
+		}
+		d.FieldUTF8("value", int(size-1))
+		if h.version == 0x52 {
+			d.FieldU8("nul", d.UintAssert(0))
+		}
+	})
+}
+
+// counts are C ints, negative is a broken chunk
+func decodeCount(h *header, d *decode.D, name string) int {
+	n := d.FieldS(name, h.intSize*8)
+	if n < 0 {
+		d.Fatalf("negative %s %d", name, n)
+	}
+	return int(n)
+}
+
+func decodeInstruction(h *header, d *decode.D) {
+	word := d.FieldU32("word", scalar.UintHex)
+	op := word & 0x3f
+	d.FieldValueUint("op", op, h.opSyms)
+	if int(op) >= len(h.opcodes) {
+		d.FieldValueUint("operands", word>>6)
+		return
+	}
+	def := h.opcodes[op]
+	rk := func(name string, v uint64) {
+		if strings.Contains(def.rk, name) && v&0x100 != 0 {
+			d.FieldValueUint(name, v, scalar.UintDescription(fmt.Sprintf("constant %d", v&0xff)))
+			return
+		}
+		d.FieldValueUint(name, v)
+	}
+	switch def.mode {
+	case iABC:
+		d.FieldValueUint("a", (word>>6)&0xff)
+		rk("b", word>>23)
+		rk("c", (word>>14)&0x1ff)
+	case iABx:
+		d.FieldValueUint("a", (word>>6)&0xff)
+		d.FieldValueUint("bx", word>>14)
+	case iAsBx:
+		d.FieldValueUint("a", (word>>6)&0xff)
+		d.FieldValueSint("sbx", int64(word>>14)-0x1ffff)
+	case iAx:
+		d.FieldValueUint("ax", word>>6)
+	}
+}
+
+func decodeConstant(h *header, d *decode.D) {
+	typ := d.FieldU8("type", constantTypeMap)
+	switch {
+	case typ == 0x00:
+		d.FieldValueAny("value", nil)
+	case typ == 0x01:
+		d.FieldU8("value", scalar.UintMapSymBool{0: false, 1: true})
+	case typ == 0x03 && h.integral:
+		d.FieldS("value", h.numberSize*8)
+	case typ == 0x03:
+		d.FieldF("value", h.numberSize*8)
+	case typ == 0x13 && h.version == 0x53:
+		d.FieldS("value", h.integerSize*8)
+	case typ == 0x04, typ == 0x14 && h.version == 0x53:
+		decodeString(h, d, "string")
+	default:
+		d.Fatalf("unknown constant type %d", typ)
+	}
+}
+
+func decodeUpvalue(d *decode.D) {
+	d.FieldU8("instack", scalar.UintMapSymBool{0: false, 1: true})
+	d.FieldU8("idx")
+}
+
+func decodeFunction(h *header, d *decode.D) {
+	if h.version == 0x53 {
+		decodeString(h, d, "source")
+	}
+	d.FieldS("linedefined", h.intSize*8)
+	d.FieldS("lastlinedefined", h.intSize*8)
+	d.FieldU8("numparams")
+	d.FieldU8("is_vararg")
+	d.FieldU8("maxstacksize")
+
+	n := decodeCount(h, d, "sizecode")
+	d.FieldArray("code", func(d *decode.D) {
+		for i := 0; i < n; i++ {
+			d.FieldStruct("instruction", func(d *decode.D) { decodeInstruction(h, d) })
+		}
+	})
+	n = decodeCount(h, d, "sizek")
+	d.FieldArray("constants", func(d *decode.D) {
+		for i := 0; i < n; i++ {
+			d.FieldStruct("constant", func(d *decode.D) { decodeConstant(h, d) })
+		}
+	})
+
+	protos := func() {
+		n := decodeCount(h, d, "sizep")
+		d.FieldArray("protos", func(d *decode.D) {
+			for i := 0; i < n; i++ {
+				d.FieldStruct("function", func(d *decode.D) { decodeFunction(h, d) })
+			}
+		})
+	}
+	upvalues := func() {
+		n := decodeCount(h, d, "sizeupvalues")
+		d.FieldArray("upvalues", func(d *decode.D) {
+			for i := 0; i < n; i++ {
+				d.FieldStruct("upvalue", decodeUpvalue)
+			}
+		})
+	}
+	if h.version == 0x52 {
+		protos()
+		upvalues()
+	} else {
+		upvalues()
+		protos()
+	}
+
+	d.FieldStruct("debug", func(d *decode.D) {
+		if h.version == 0x52 {
+			decodeString(h, d, "source")
+		}
+		n := decodeCount(h, d, "sizelineinfo")
+		d.FieldArray("lineinfo", func(d *decode.D) {
+			for i := 0; i < n; i++ {
+				d.FieldS("line", h.intSize*8)
+			}
+		})
+		n = decodeCount(h, d, "sizelocvars")
+		d.FieldArray("locvars", func(d *decode.D) {
+			for i := 0; i < n; i++ {
+				d.FieldStruct("locvar", func(d *decode.D) {
+					decodeString(h, d, "varname")
+					d.FieldS("startpc", h.intSize*8)
+					d.FieldS("endpc", h.intSize*8)
+				})
+			}
+		})
+		n = decodeCount(h, d, "sizeupvalue_names")
+		d.FieldArray("upvalue_names", func(d *decode.D) {
+			for i := 0; i < n; i++ {
+				decodeString(h, d, "name")
+			}
+		})
+	})
+}
+
+func decodeLua(version uint64, d *decode.D) any {
+	h := &header{version: version, opcodes: opcodes52}
+	if version == 0x53 {
+		h.opcodes = opcodes53
+	}
+	h.opSyms = scalar.UintMapSymStr{}
+	for i, op := range h.opcodes {
+		h.opSyms[uint64(i)] = op.name
+	}
+
+	d.FieldStruct("header", func(d *decode.D) {
+		d.FieldRawLen("magic", 4*8, d.AssertBitBuf([]byte("\x1bLua")))
+		d.FieldU8("version", d.UintAssert(version), scalar.UintHex)
+		if version == 0x52 {
+			decodeHeader52(h, d)
+		} else {
+			decodeHeader53(h, d)
+		}
+	})
+	d.Endian = h.endian
+
+	if version == 0x53 {
+		d.FieldU8("sizeupvalues", scalar.UintDescription("of main function"))
+	}
+	d.FieldStruct("function", func(d *decode.D) { decodeFunction(h, d) })
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package lua
+
+// see lopcodes.h and lopcodes.c of each version
+
+const (
+	iABC = iota
+	iABx
+	iAsBx
+	iAx
+)
+
+// rk is which of B and C are RK operands, register or constant if bit 8 is set
+type opcode struct {
+	name string
+	mode int
+	rk   string
+}
+
+var opcodes52 = []opcode{
+	{"MOVE", iABC, ""},
+	{"LOADK", iABx, ""},
+	{"LOADKX", iABC, ""},
+	{"LOADBOOL", iABC, ""},
+	{"LOADNIL", iABC, ""},
+	{"GETUPVAL", iABC, ""},
+	{"GETTABUP", iABC, "c"},
+	{"GETTABLE", iABC, "c"},
+	{"SETTABUP", iABC, "bc"},
+	{"SETUPVAL", iABC, ""},
+	{"SETTABLE", iABC, "bc"},
+	{"NEWTABLE", iABC, ""},
+	{"SELF", iABC, "c"},
+	{"ADD", iABC, "bc"},
+	{"SUB", iABC, "bc"},
+	{"MUL", iABC, "bc"},
+	{"DIV", iABC, "bc"},
+	{"MOD", iABC, "bc"},
+	{"POW", iABC, "bc"},
+	{"UNM", iABC, ""},
+	{"NOT", iABC, ""},
+	{"LEN", iABC, ""},
+	{"CONCAT", iABC, ""},
+	{"JMP", iAsBx, ""},
+	{"EQ", iABC, "bc"},
+	{"LT", iABC, "bc"},
+	{"LE", iABC, "bc"},
+	{"TEST", iABC, ""},
+	{"TESTSET", iABC, ""},
+	{"CALL", iABC, ""},
+	{"TAILCALL", iABC, ""},
+	{"RETURN", iABC, ""},
+	{"FORLOOP", iAsBx, ""},
+	{"FORPREP", iAsBx, ""},
+	{"TFORCALL", iABC, ""},
+	{"TFORLOOP", iAsBx, ""},
+	{"SETLIST", iABC, ""},
+	{"CLOSURE", iABx, ""},
+	{"VARARG", iABC, ""},
+	{"EXTRAARG", iAx, ""},
+}
+
+// 5.3 adds integer division and bitwise operators and moves DIV after POW
+var opcodes53 = []opcode{
+	{"MOVE", iABC, ""},
+	{"LOADK", iABx, ""},
+	{"LOADKX", iABC, ""},
+	{"LOADBOOL", iABC, ""},
+	{"LOADNIL", iABC, ""},
+	{"GETUPVAL", iABC, ""},
+	{"GETTABUP", iABC, "c"},
+	{"GETTABLE", iABC, "c"},
+	{"SETTABUP", iABC, "bc"},
+	{"SETUPVAL", iABC, ""},
+	{"SETTABLE", iABC, "bc"},
+	{"NEWTABLE", iABC, ""},
+	{"SELF", iABC, "c"},
+	{"ADD", iABC, "bc"},
+	{"SUB", iABC, "bc"},
+	{"MUL", iABC, "bc"},
+	{"MOD", iABC, "bc"},
+	{"POW", iABC, "bc"},
+	{"DIV", iABC, "bc"},
+	{"IDIV", iABC, "bc"},
+	{"BAND", iABC, "bc"},
+	{"BOR", iABC, "bc"},
+	{"BXOR", iABC, "bc"},
+	{"SHL", iABC, "bc"},
+	{"SHR", iABC, "bc"},
+	{"UNM", iABC, ""},
+	{"BNOT", iABC, ""},
+	{"NOT", iABC, ""},
+	{"LEN", iABC, ""},
+	{"CONCAT", iABC, ""},
+	{"JMP", iAsBx, ""},
+	{"EQ", iABC, "bc"},
+	{"LT", iABC, "bc"},
+	{"LE", iABC, "bc"},
+	{"TEST", iABC, ""},
+	{"TESTSET", iABC, ""},
+	{"CALL", iABC, ""},
+	{"TAILCALL", iABC, ""},
+	{"RETURN", iABC, ""},
+	{"FORLOOP", iAsBx, ""},
+	{"FORPREP", iAsBx, ""},
+	{"TFORCALL", iABC, ""},
+	{"TFORLOOP", iAsBx, ""},
+	{"SETLIST", iABC, ""},
+	{"CLOSURE", iABx, ""},
+	{"VARARG", iABC, ""},
+	{"EXTRAARG", iAx, ""},
+}
@@ -0,0 +1,92 @@
+package luajit
+
+// comments by file offset for disassemblers, ex when a dump is embedded in a
+// larger binary
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc1("_luajit_annotations", func(_ *interp.Interp, c any, base int) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Annotations(dump, base)
+	})
+	interp.RegisterFunc1("_luajit_annotations_py", func(_ *interp.Interp, c any, base int) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return AnnotationsPython(dump, base)
+	})
+}
+
+// Annotations is a comment for the start of each proto and for each
+// instruction, as in luajit_bclist. Offsets are from the start of the input
+// plus base, ex the offset of the dump in a file
+func Annotations(dump *Dump, base int) []any {
+	annotations := []any{}
+	for _, p := range dump.Protos {
+		comment := fmt.Sprintf("LuaJIT proto %d %s", p.Index, dump.ProtoName(p))
+		if !dump.Strip() {
+			comment += " " + dump.protoLoc(p)
+		}
+		annotations = append(annotations, map[string]any{
+			"offset":  base + dump.Offset + p.Offset,
+			"length":  p.InsOffset - p.Offset,
+			"proto":   p.Index,
+			"comment": comment,
+		})
+		for pc := range p.Ins {
+			annotations = append(annotations, map[string]any{
+				"offset":  base + dump.Offset + p.InsOffset + pc*4,
+				"length":  4,
+				"proto":   p.Index,
+				"pc":      pc,
+				"comment": strings.TrimSpace(dump.bcline(p, pc, false)),
+			})
+		}
+	}
+	return annotations
+}
+
+// sets comments by file offset in IDA (idc) or Ghidra (Jython)
+const annotationsPythonScript = `
+def luajit_comment(offset, text):
+    try:
+        import ida_loader
+        import idc
+        ea = ida_loader.get_fileregion_ea(offset)
+        if ea != idc.BADADDR:
+            idc.set_cmt(ea, text, 0)
+    except ImportError:
+        for a in currentProgram.getMemory().locateAddressesForFileOffset(offset):
+            setEOLComment(a, text)
+
+for a in luajit_annotations:
+    luajit_comment(a["offset"], a["comment"])
+`
+
+// AnnotationsPython is a script for IDA or Ghidra adding Annotations as
+// comments
+func AnnotationsPython(dump *Dump, base int) string {
+	sb := &strings.Builder{}
+	sb.WriteString("# -*- coding: utf-8 -*-\n")
+	sb.WriteString("# LuaJIT bytecode comments from fq luajit_annotations_py, run in IDA or Ghidra\n")
+	sb.WriteString("luajit_annotations = [\n")
+	for _, a := range Annotations(dump, base) {
+		// JSON objects without null or bool are python dicts
+		b, _ := json.Marshal(a)
+		sb.WriteString("    " + string(b) + ",\n")
+	}
+	sb.WriteString("]\n")
+	sb.WriteString(annotationsPythonScript)
+	return sb.String()
+}
@@ -0,0 +1,439 @@
+package luajit
+
+// assembler for a simple textual bytecode syntax, one instruction or directive
+// per line:
+//
+//	; comment, also --
+//	.version 2          dump version, default 2
+//	.flags 2            dump flags, default 2 (stripped)
+//	.name "@file.lua"   chunk name, clears the strip flag
+//	.proto params=1 framesize=3 vararg
+//	.uv 0xc000          upvalue, raw value
+//	.kstr "s"           string constant, next kgc operand index
+//	.kchild             child proto constant, takes protos before like LuaJIT
+//	.knum 1.5           number constant, next knum operand index
+//	loop:               label
+//	0001 ADDVN 1 1 0    optional pc, operands A B C or A D
+//	JMP 0 => loop       jump target is a label or pc, "=>" is optional
+//	KSTR 0 "s"          string operands can also be literals
+//
+// instructions as in luajit_cfg_dot labels can be used as is, framesize is
+// guessed from used slots if not set
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_asm", func(_ *interp.Interp, c string) any {
+		buf, err := Assemble(c)
+		if err != nil {
+			return err
+		}
+		return toBinary(buf)
+	})
+}
+
+type asmFixup struct {
+	pc    int
+	label string
+	line  int
+}
+
+type asmProto struct {
+	p      *Proto
+	labels map[string]int
+	// jumps to labels resolved when done
+	fixups []asmFixup
+	// in operand order
+	kgc []KGC
+	// set framesize from used slots
+	autoFrameSize bool
+}
+
+type assembler struct {
+	dump   *Dump
+	protos []*asmProto
+	line   int
+}
+
+func (a *assembler) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", a.line, fmt.Sprintf(format, args...))
+}
+
+// split line into tokens, quoted strings are one token
+func asmTokens(line string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		r := rune(line[i])
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			i++
+		case r == ';' || strings.HasPrefix(line[i:], "--"):
+			return tokens, nil
+		case r == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(line) && !unicode.IsSpace(rune(line[j])) && line[j] != ',' && line[j] != ';'; j++ {
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func asmInt(s string, min int64, max int64) (int64, error) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q is not a number %d-%d", s, min, max)
+	}
+	return n, nil
+}
+
+func (a *assembler) cur() (*asmProto, error) {
+	if len(a.protos) == 0 {
+		return nil, a.errorf("missing .proto")
+	}
+	return a.protos[len(a.protos)-1], nil
+}
+
+// kgc operand index of string constant, added if not found
+func (ap *asmProto) str(s string) int {
+	for i, k := range ap.kgc {
+		if k.Type == kgcStr && k.Str == s {
+			return i
+		}
+	}
+	ap.kgc = append(ap.kgc, KGC{Type: kgcStr, Str: s})
+	return len(ap.kgc) - 1
+}
+
+func (a *assembler) directive(tokens []string) error {
+	arg := func(i int) (string, error) {
+		if i >= len(tokens) {
+			return "", a.errorf("%s missing argument", tokens[0])
+		}
+		return tokens[i], nil
+	}
+
+	switch tokens[0] {
+	case ".version", ".flags":
+		s, err := arg(1)
+		if err != nil {
+			return err
+		}
+		n, err := asmInt(s, 0, 0xff)
+		if err != nil {
+			return a.errorf("%s", err)
+		}
+		if tokens[0] == ".version" {
+			a.dump.Version = uint64(n)
+		} else {
+			a.dump.Flags = uint64(n)
+		}
+	case ".name":
+		s, err := arg(1)
+		if err != nil {
+			return err
+		}
+		if a.dump.Name, err = strconv.Unquote(s); err != nil {
+			return a.errorf("bad string %s", s)
+		}
+		a.dump.Flags &^= dumpFlagStrip
+	case ".proto":
+		ap := &asmProto{
+			p:      &Proto{Index: len(a.protos)},
+			labels: map[string]int{},
+		}
+		framesize := -1
+		for _, t := range tokens[1:] {
+			k, v, _ := strings.Cut(t, "=")
+			switch k {
+			case "vararg":
+				ap.p.Flags |= protoFlagVararg
+			case "params", "framesize":
+				n, err := asmInt(v, 0, 0xff)
+				if err != nil {
+					return a.errorf("%s", err)
+				}
+				if k == "params" {
+					ap.p.NumParams = uint8(n)
+				} else {
+					framesize = int(n)
+				}
+			default:
+				return a.errorf("unknown .proto argument %q", t)
+			}
+		}
+		ap.p.FrameSize = uint8(framesize)
+		ap.autoFrameSize = framesize < 0
+		a.protos = append(a.protos, ap)
+	case ".uv", ".kstr", ".kchild", ".knum":
+		ap, err := a.cur()
+		if err != nil {
+			return err
+		}
+		if tokens[0] == ".kchild" {
+			ap.kgc = append(ap.kgc, KGC{Type: kgcChild})
+			ap.p.Flags |= protoFlagChild
+			return nil
+		}
+		s, err := arg(1)
+		if err != nil {
+			return err
+		}
+		switch tokens[0] {
+		case ".uv":
+			n, err := asmInt(s, 0, 0xffff)
+			if err != nil {
+				return a.errorf("%s", err)
+			}
+			ap.p.UV = append(ap.p.UV, uint16(n))
+		case ".kstr":
+			str, err := strconv.Unquote(s)
+			if err != nil {
+				return a.errorf("bad string %s", s)
+			}
+			ap.kgc = append(ap.kgc, KGC{Type: kgcStr, Str: str})
+		case ".knum":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return a.errorf("bad number %s", s)
+			}
+			if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 && !(f == 0 && math.Signbit(f)) {
+				ap.p.KNum = append(ap.p.KNum, KNum{IsInt: true, Int: int32(f)})
+			} else {
+				ap.p.KNum = append(ap.p.KNum, KNum{Num: f})
+			}
+		}
+	default:
+		return a.errorf("unknown directive %s", tokens[0])
+	}
+	return nil
+}
+
+func (a *assembler) instruction(tokens []string) error {
+	ap, err := a.cur()
+	if err != nil {
+		return err
+	}
+
+	// skip pc and jump arrows as printed by listings
+	var ts []string
+	for i, t := range tokens {
+		if t == "=>" || (i == 0 && t[0] >= '0' && t[0] <= '9') {
+			continue
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) == 0 {
+		return nil
+	}
+
+	pc := len(ap.p.Ins)
+	name := strings.ToUpper(ts[0])
+	var ins Ins
+	var def *BcDef
+	for i := range a.dump.Opcodes {
+		if a.dump.Opcodes[i].Name == name {
+			ins.Op = uint8(i)
+			def = &a.dump.Opcodes[i]
+		}
+	}
+	if def == nil {
+		return a.errorf("unknown op %s", ts[0])
+	}
+	want := 3
+	if def.HasD() {
+		want = 2
+	}
+	if len(ts)-1 != want {
+		return a.errorf("%s expects %d operands", name, want)
+	}
+
+	// operand, string literal for str operands and label for jumps
+	operand := func(t string, mode int, min int64, max int64) (int64, error) {
+		switch {
+		case mode == BcMstr && strings.HasPrefix(t, `"`):
+			s, err := strconv.Unquote(t)
+			if err != nil {
+				return 0, a.errorf("bad string %s", t)
+			}
+			return int64(ap.str(s)), nil
+		case mode == BcMjump:
+			if n, err := strconv.ParseInt(t, 10, 64); err == nil {
+				// target pc as relative to next instruction
+				return n - int64(pc) - 1 + 0x8000, nil
+			}
+			ap.fixups = append(ap.fixups, asmFixup{pc: pc, label: t, line: a.line})
+			return 0x8000, nil
+		}
+		n, err := asmInt(t, min, max)
+		if err != nil {
+			return 0, a.errorf("%s", err)
+		}
+		return n, nil
+	}
+
+	av, err := operand(ts[1], def.MA, 0, 0xff)
+	if err != nil {
+		return err
+	}
+	ins.A = uint8(av)
+	if def.HasD() {
+		min, max := int64(0), int64(0xffff)
+		if def.IsLits() {
+			min, max = -0x8000, 0x7fff
+		}
+		dv, err := operand(ts[2], def.MC, min, max)
+		if err != nil {
+			return err
+		}
+		ins.D = uint16(dv)
+		ins.B = uint8(ins.D >> 8)
+		ins.C = uint8(ins.D)
+	} else {
+		bv, err := operand(ts[2], def.MB, 0, 0xff)
+		if err != nil {
+			return err
+		}
+		cv, err := operand(ts[3], def.MC, 0, 0xff)
+		if err != nil {
+			return err
+		}
+		ins.B = uint8(bv)
+		ins.C = uint8(cv)
+		ins.D = uint16(ins.B)<<8 | uint16(ins.C)
+	}
+	ap.p.Ins = append(ap.p.Ins, ins)
+	return nil
+}
+
+func (a *assembler) finishProto(ap *asmProto) error {
+	for _, f := range ap.fixups {
+		t, ok := ap.labels[f.label]
+		if !ok {
+			return fmt.Errorf("line %d: unknown label %s", f.line, f.label)
+		}
+		ap.p.Ins[f.pc].D = uint16(t - f.pc - 1 + 0x8000)
+	}
+
+	fs := int(ap.p.NumParams)
+	for pc, ins := range ap.p.Ins {
+		def := a.dump.Opcodes.Get(int(ins.Op))
+		operands := [][2]int{{def.MA, int(ins.A)}, {def.MB, int(ins.B)}, {def.MC, int(ins.C)}}
+		if def.HasD() {
+			operands = [][2]int{{def.MA, int(ins.A)}, {def.MC, int(ins.D)}}
+		}
+		for _, o := range operands {
+			mode, v := o[0], o[1]
+			var n int
+			switch mode {
+			case BcMdst, BcMvar, BcMrbase:
+				n = v + 1
+			case BcMbase:
+				// calls, loops and varg use slots after base, guess
+				n = v + 4
+				if int(ins.B) > 4 && !def.HasD() {
+					n = v + int(ins.B)
+				}
+			case BcMstr, BcMtab, BcMfunc, BcMcdata:
+				if v >= len(ap.kgc) {
+					return fmt.Errorf("proto %d pc %d: kgc %d out of range", ap.p.Index, pc, v)
+				}
+			case BcMnum:
+				if v >= len(ap.p.KNum) {
+					return fmt.Errorf("proto %d pc %d: knum %d out of range", ap.p.Index, pc, v)
+				}
+			case BcMuv:
+				if v >= len(ap.p.UV) {
+					return fmt.Errorf("proto %d pc %d: uv %d out of range", ap.p.Index, pc, v)
+				}
+			}
+			if n > fs {
+				fs = n
+			}
+		}
+	}
+	if ap.autoFrameSize {
+		if fs > 0xff {
+			fs = 0xff
+		}
+		ap.p.FrameSize = uint8(fs)
+	}
+	// dump order is reversed operand order
+	for i := len(ap.kgc) - 1; i >= 0; i-- {
+		ap.p.KGC = append(ap.p.KGC, ap.kgc[i])
+	}
+	return nil
+}
+
+// Assemble assembles text to a dump, the result is checked by parsing it
+func Assemble(text string) ([]byte, error) {
+	a := &assembler{dump: &Dump{Version: 2, Flags: dumpFlagStrip}}
+	a.dump.Opcodes = versionOpcodes[a.dump.Version]
+
+	for i, line := range strings.Split(text, "\n") {
+		a.line = i + 1
+		tokens, err := asmTokens(line)
+		if err != nil {
+			return nil, a.errorf("%s", err)
+		}
+		switch {
+		case len(tokens) == 0:
+		case strings.HasPrefix(tokens[0], "."):
+			if err := a.directive(tokens); err != nil {
+				return nil, err
+			}
+			if tokens[0] == ".version" {
+				if a.dump.Opcodes = versionOpcodes[a.dump.Version]; a.dump.Opcodes == nil {
+					a.dump.Opcodes = opcodesLuaJIT21
+				}
+			}
+		case len(tokens) == 1 && strings.HasSuffix(tokens[0], ":"):
+			ap, err := a.cur()
+			if err != nil {
+				return nil, err
+			}
+			ap.labels[strings.TrimSuffix(tokens[0], ":")] = len(ap.p.Ins)
+		default:
+			if err := a.instruction(tokens); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(a.protos) == 0 {
+		return nil, fmt.Errorf("no .proto")
+	}
+
+	for _, ap := range a.protos {
+		if err := a.finishProto(ap); err != nil {
+			return nil, err
+		}
+		a.dump.Protos = append(a.dump.Protos, ap.p)
+	}
+
+	buf := a.dump.Encode()
+	if _, err := ParseDump(buf, a.dump.Opcodes); err != nil {
+		return nil, fmt.Errorf("assembled dump is invalid: %w", err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,196 @@
+package luajit
+
+// listing in the same format as luajit -bl, see jit/bc.lua bcline and bcdump
+// and lj_debug_pushloc for function locations
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_bclist", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return BCList(dump)
+	})
+}
+
+// number as lua tostring, %.14g
+func luaNumString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', 14, 64)
+}
+
+// control characters as in jit/bc.lua ctlsub
+func bclistQuote(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&sb, `\%03d`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	q := sb.String()
+	if len(s) > 40 {
+		if len(q) > 40 {
+			q = q[:40]
+		}
+		return `"` + q + `"~`
+	}
+	return `"` + q + `"`
+}
+
+// function location as lj_debug_pushloc, name and first line, stripped dumps
+// have no name or lines so "?" is used
+func (dump *Dump) protoLoc(p *Proto) string {
+	name := dump.Name
+	switch {
+	case dump.Strip():
+		return "?"
+	case strings.HasPrefix(name, "@"):
+		name = name[1:]
+		if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+			name = name[i+1:]
+		}
+		return fmt.Sprintf("%s:%d", name, p.FirstLine)
+	case len(name) > 40:
+		// LuaJIT uses the proto address
+		return fmt.Sprintf("?:%d", p.FirstLine)
+	case strings.HasPrefix(name, "="):
+		return fmt.Sprintf("%s:%d", name[1:], p.FirstLine)
+	default:
+		return fmt.Sprintf("%q:%d", name, p.FirstLine)
+	}
+}
+
+func (p *Proto) uvName(i int) string {
+	if i < len(p.UVNames) {
+		return p.UVNames[i]
+	}
+	return ""
+}
+
+// instruction index pc as bcline, pcs in the listing are runtime pcs so index + 1
+func (dump *Dump) bcline(p *Proto, pc int, target bool) string {
+	ins := p.Ins[pc]
+	def := dump.Opcodes.Get(int(ins.Op))
+	rpc := pc + 1
+
+	prefix := "  "
+	if target {
+		prefix = "=>"
+	}
+	a := ""
+	if def.MA != BcMnone {
+		a = strconv.Itoa(int(ins.A))
+	}
+	s := fmt.Sprintf("%04d %s %-6s %3s ", rpc, prefix, def.Name, a)
+
+	d := int(ins.D)
+	if def.IsJump() {
+		return fmt.Sprintf("%s=> %04d\n", s, rpc+d-0x7fff)
+	}
+	if def.MB != BcMnone {
+		d = int(ins.C)
+	} else if def.MC == BcMnone {
+		return s + "\n"
+	}
+
+	var kc string
+	hasKC := false
+	switch def.MC {
+	case BcMstr:
+		if k := p.KGCByD(d); k != nil {
+			kc, hasKC = bclistQuote(k.Str), true
+		}
+	case BcMnum:
+		if k := p.KNumByD(d); k != nil {
+			switch {
+			case k.IsInt:
+				kc = strconv.Itoa(int(k.Int))
+			case def.Name == "TSETM":
+				kc = luaNumString(k.Num - (1 << 52))
+			default:
+				kc = luaNumString(k.Num)
+			}
+			hasKC = true
+		}
+	case BcMfunc:
+		if k := p.KGCByD(d); k != nil && k.Child != nil {
+			kc, hasKC = dump.protoLoc(k.Child), true
+		}
+	case BcMuv:
+		kc, hasKC = p.uvName(d), true
+	}
+	if def.MA == BcMuv {
+		if hasKC {
+			kc = p.uvName(int(ins.A)) + " ; " + kc
+		} else {
+			kc, hasKC = p.uvName(int(ins.A)), true
+		}
+	}
+
+	if def.MB != BcMnone {
+		if hasKC {
+			return fmt.Sprintf("%s%3d %3d  ; %s\n", s, ins.B, d, kc)
+		}
+		return fmt.Sprintf("%s%3d %3d\n", s, ins.B, d)
+	}
+	if hasKC {
+		return fmt.Sprintf("%s%3d      ; %s\n", s, d, kc)
+	}
+	if def.IsLits() {
+		d = int(int16(ins.D))
+	}
+	return fmt.Sprintf("%s%3d\n", s, d)
+}
+
+func (dump *Dump) bcdump(sb *strings.Builder, p *Proto) {
+	for _, c := range p.Children() {
+		dump.bcdump(sb, c)
+	}
+
+	fmt.Fprintf(sb, "-- BYTECODE -- %s-%d\n", dump.protoLoc(p), p.FirstLine+p.NumLine)
+	targets := map[int]bool{}
+	for pc, ins := range p.Ins {
+		if dump.Opcodes.Get(int(ins.Op)).IsJump() {
+			targets[ins.Target(pc)] = true
+		}
+	}
+	for pc := range p.Ins {
+		sb.WriteString(dump.bcline(p, pc, targets[pc]))
+	}
+	sb.WriteString("\n")
+}
+
+// BCList is a listing of all protos in the same format as luajit -bl,
+// children before parents
+func BCList(dump *Dump) string {
+	var sb strings.Builder
+	if main := dump.Main(); main != nil {
+		dump.bcdump(&sb, main)
+	}
+	return sb.String()
+}
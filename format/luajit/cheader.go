@@ -0,0 +1,109 @@
+package luajit
+
+// dump as a C array like luajit -b -t h or -t c, see jit/bcsave.lua bcsave_c
+// and detectmodname
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
+)
+
+type cHeaderOpts struct {
+	Name string
+	Type string
+}
+
+func init() {
+	interp.RegisterFunc1("_luajit_c_header", func(_ *interp.Interp, c any, opts cHeaderOpts) any {
+		br, err := interp.ToBitReader(c)
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(bitio.NewIOReader(br))
+		if err != nil {
+			return err
+		}
+		// the dump without shebang or other prefix
+		if i := bytes.Index(buf, []byte("\x1bLJ")); i > 0 {
+			buf = buf[i:]
+		}
+		name := opts.Name
+		if name == "" {
+			dump, err := toDump(c)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(dump.Name, "@") {
+				return fmt.Errorf("cannot derive module name from chunk name, set name")
+			}
+			name = dump.Name[1:]
+		}
+		s, err := CHeader(buf, name, opts.Type)
+		if err != nil {
+			return err
+		}
+		return s
+	})
+}
+
+var cModNameRe = regexp.MustCompile(`^[\w.\-]+$`)
+
+// module name from a file name like detectmodname, without directories and
+// extension and with . and - as _
+func cModName(s string) (string, bool) {
+	if i := strings.LastIndexAny(s, `/\`); i >= 0 {
+		s = s[i+1:]
+	}
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[:i]
+	}
+	if !cModNameRe.MatchString(s) {
+		return "", false
+	}
+	return strings.NewReplacer(".", "_", "-", "_").Replace(s), true
+}
+
+// CHeader is buf as luaJIT_BC_<name> array, typ "h" (default) is a static
+// array with a _SIZE define and "c" an exported array. name is a module or
+// file name
+func CHeader(buf []byte, name string, typ string) (string, error) {
+	modName, ok := cModName(name)
+	if !ok {
+		return "", fmt.Errorf("cannot derive module name from %q", name)
+	}
+
+	var sb strings.Builder
+	switch typ {
+	case "", "h":
+		fmt.Fprintf(&sb, "#define luaJIT_BC_%s_SIZE %d\n", modName, len(buf))
+		fmt.Fprintf(&sb, "static const unsigned char luaJIT_BC_%s[] = {\n", modName)
+	case "c":
+		sb.WriteString("#ifdef __cplusplus\nextern \"C\"\n#endif\n#ifdef _WIN32\n__declspec(dllexport)\n#endif\n")
+		fmt.Fprintf(&sb, "const unsigned char luaJIT_BC_%s[] = {\n", modName)
+	default:
+		return "", fmt.Errorf("unknown type %q, h or c", typ)
+	}
+
+	// lines are wrapped before going over 78 characters including commas
+	var line []string
+	m := 0
+	for _, b := range buf {
+		s := strconv.Itoa(int(b))
+		m += len(s) + 1
+		if m > 78 {
+			sb.WriteString(strings.Join(line, ",") + ",\n")
+			line = line[:0]
+			m = len(s) + 1
+		}
+		line = append(line, s)
+	}
+	sb.WriteString(strings.Join(line, ",") + "\n};\n")
+	return sb.String(), nil
+}
@@ -0,0 +1,985 @@
+package luajit
+
+// best effort bytecode to pseudo-Lua lifter
+//
+// Each instruction becomes a statement, with debug info temporaries (slots
+// without a variable name) are folded into the expression that uses them.
+// if/else, while, repeat, numeric and generic for are reconstructed from jump
+// patterns the LuaJIT parser emits, everything else falls back to goto.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_decompile", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Decompile(dump)
+	})
+}
+
+const (
+	exprName  = iota // name, index or call, can be used as prefix expression
+	exprConst        // literal or other expression that needs parentheses as prefix
+)
+
+// operator precedence, higher binds tighter, 0 is an atom
+const (
+	precOr = iota + 1
+	precAnd
+	precCmp
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+)
+
+var binPrec = map[string]int{
+	"or":  precOr,
+	"and": precAnd,
+	"<":   precCmp,
+	">":   precCmp,
+	"<=":  precCmp,
+	">=":  precCmp,
+	"~=":  precCmp,
+	"==":  precCmp,
+	"..":  precConcat,
+	"+":   precAdd,
+	"-":   precAdd,
+	"*":   precMul,
+	"/":   precMul,
+	"%":   precMul,
+	"^":   precPow,
+}
+
+type expr struct {
+	s     string
+	kind  int
+	prec  int
+	reads []int
+}
+
+func (e expr) prefix() string {
+	if e.kind == exprName {
+		return e.s
+	}
+	return "(" + e.s + ")"
+}
+
+// e as operand of operator with precedence prec, paren is true if equal
+// precedence also needs parentheses because of associativity
+func (e expr) operand(prec int, paren bool) string {
+	if e.prec == 0 || e.prec > prec || (e.prec == prec && !paren) {
+		return e.s
+	}
+	return "(" + e.s + ")"
+}
+
+// .. and ^ are right associative
+func binExpr(a expr, op string, b expr) expr {
+	p := binPrec[op]
+	right := op == ".." || op == "^"
+	return expr{
+		s:     a.operand(p, right) + " " + op + " " + b.operand(p, !right),
+		kind:  exprConst,
+		prec:  p,
+		reads: append(append([]int{}, a.reads...), b.reads...),
+	}
+}
+
+func unaryExpr(op string, a expr) expr {
+	s := a.operand(precUnary, false)
+	if op == "-" && strings.HasPrefix(s, "-") {
+		// -- would start a comment
+		s = "(" + s + ")"
+	}
+	return expr{s: op + s, kind: exprConst, prec: precUnary, reads: a.reads}
+}
+
+func notExpr(a expr) expr {
+	return unaryExpr("not ", a)
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+func isLuaIdent(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func luaQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == utf8.RuneError && n == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&sb, `\%03d`, s[i])
+		default:
+			sb.WriteRune(r)
+		}
+		i += n
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func luaNum(f float64) expr {
+	switch {
+	case math.IsNaN(f):
+		return expr{s: "0/0", kind: exprConst, prec: precMul}
+	case math.IsInf(f, 1):
+		return expr{s: "1/0", kind: exprConst, prec: precMul}
+	case math.IsInf(f, -1):
+		return expr{s: "-1/0", kind: exprConst, prec: precMul}
+	case f == 0 && math.Signbit(f):
+		return expr{s: "-0.0", kind: exprConst, prec: precUnary}
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if f < 0 {
+		return expr{s: s, kind: exprConst, prec: precUnary}
+	}
+	return expr{s: s, kind: exprConst}
+}
+
+func luaInt(i int64) expr {
+	if i < 0 {
+		return expr{s: strconv.FormatInt(i, 10), kind: exprConst, prec: precUnary}
+	}
+	return expr{s: strconv.FormatInt(i, 10), kind: exprConst}
+}
+
+func luaKNum(k *KNum) expr {
+	switch {
+	case k == nil:
+		return expr{s: "nil --[[ bad number ]]", kind: exprConst}
+	case k.IsInt:
+		return luaInt(int64(k.Int))
+	default:
+		return luaNum(k.Num)
+	}
+}
+
+func luaPri(d int) expr {
+	switch d {
+	case 0:
+		return expr{s: "nil", kind: exprConst}
+	case 1:
+		return expr{s: "false", kind: exprConst}
+	default:
+		return expr{s: "true", kind: exprConst}
+	}
+}
+
+func luaKTabK(k KTabK) expr {
+	switch k.Type {
+	case ktabNil:
+		return luaPri(0)
+	case ktabFalse:
+		return luaPri(1)
+	case ktabTrue:
+		return luaPri(2)
+	case ktabInt:
+		return luaInt(int64(k.Int))
+	case ktabNum:
+		return luaNum(k.Num)
+	default:
+		return expr{s: luaQuote(k.Str), kind: exprConst}
+	}
+}
+
+func luaKTab(t *KTab) expr {
+	var items []string
+	for i, v := range t.Array {
+		switch {
+		case i == 0 && v.Type == ktabNil:
+			// array part starts at index 0 which is usually unused
+		case i == 0:
+			items = append(items, "[0] = "+luaKTabK(v).s)
+		default:
+			items = append(items, luaKTabK(v).s)
+		}
+	}
+	for _, kv := range t.Hash {
+		if kv[0].Type == ktabStr && isLuaIdent(kv[0].Str) {
+			items = append(items, kv[0].Str+" = "+luaKTabK(kv[1]).s)
+		} else {
+			items = append(items, "["+luaKTabK(kv[0]).s+"] = "+luaKTabK(kv[1]).s)
+		}
+	}
+	if len(items) == 0 {
+		return expr{s: "{}", kind: exprConst}
+	}
+	return expr{s: "{" + strings.Join(items, ", ") + "}", kind: exprConst}
+}
+
+func luaKGC(k *KGC) expr {
+	switch {
+	case k == nil:
+		return expr{s: "nil --[[ bad constant ]]", kind: exprConst}
+	case k.Type == kgcStr:
+		return expr{s: luaQuote(k.Str), kind: exprConst}
+	case k.Type == kgcTab:
+		return luaKTab(k.Tab)
+	case k.Type == kgcI64:
+		if k.I64 < 0 {
+			return expr{s: strconv.FormatInt(k.I64, 10) + "LL", kind: exprConst, prec: precUnary}
+		}
+		return expr{s: strconv.FormatInt(k.I64, 10) + "LL", kind: exprConst}
+	case k.Type == kgcU64:
+		return expr{s: strconv.FormatUint(k.U64, 10) + "ULL", kind: exprConst}
+	case k.Type == kgcComplex:
+		return binExpr(luaNum(k.Real), "+", expr{s: luaNum(k.Imag).s + "i", kind: exprConst})
+	default:
+		return expr{s: fmt.Sprintf("function_%d", k.Child.Index), kind: exprName}
+	}
+}
+
+type luaLine struct {
+	indent int
+	text   string
+	label  int // instruction index of label marker, -1 if not a label
+	// set if line is "if <jump> then break end", fall is the negated condition
+	breakFall *expr
+}
+
+// pending temporary slots, all slots are written by e
+type pendingSlots struct {
+	slots []int
+	e     expr
+}
+
+type liftCtx struct {
+	brk   int // instruction index a break jumps to, -1 if not in a loop
+	head  int // loop head for while/repeat loops, -1 if not
+	end   int // last instruction of while/repeat loop
+	until *expr
+}
+
+type lifter struct {
+	dump     *Dump
+	p        *Proto
+	fr2      int
+	fold     bool
+	lines    []luaLine
+	indent   int
+	pending  []pendingSlots
+	consumed map[int]expr
+	multres  expr
+	targets  map[int]bool
+	gotos    map[int]bool
+	loops    map[int]int
+}
+
+func newLifter(dump *Dump, p *Proto) *lifter {
+	l := &lifter{
+		dump:     dump,
+		p:        p,
+		fold:     !dump.Strip(),
+		consumed: map[int]expr{},
+		targets:  map[int]bool{},
+		gotos:    map[int]bool{},
+		loops:    map[int]int{},
+	}
+	if dump.FR2() {
+		l.fr2 = 1
+	}
+	for pc, ins := range p.Ins {
+		if !l.isJump(pc) {
+			continue
+		}
+		t := ins.Target(pc)
+		l.targets[t] = true
+		if l.opName(pc) == "JMP" && t <= pc {
+			if h, ok := l.loops[t]; !ok || pc > h {
+				l.loops[t] = pc
+			}
+		}
+	}
+	return l
+}
+
+func (l *lifter) opName(pc int) string {
+	if pc < 0 || pc >= len(l.p.Ins) {
+		return ""
+	}
+	return l.dump.Opcodes.Get(int(l.p.Ins[pc].Op)).Name
+}
+
+func (l *lifter) isJump(pc int) bool {
+	return l.dump.Opcodes.Get(int(l.p.Ins[pc].Op)).IsJump()
+}
+
+func (l *lifter) emit(text string) {
+	l.lines = append(l.lines, luaLine{indent: l.indent, text: text, label: -1})
+}
+
+func (l *lifter) slotName(pc int, s int) string {
+	if n := l.p.VarName(pc, s); isLuaIdent(n) {
+		return n
+	}
+	return fmt.Sprintf("r%d", s)
+}
+
+// name of slot written by instruction pc, it might start a new local
+func (l *lifter) dstName(pc int, s int) string {
+	if n := l.p.VarName(pc+1, s); isLuaIdent(n) {
+		return n
+	}
+	return l.slotName(pc, s)
+}
+
+// slot written by pc is the start of a local variable
+func (l *lifter) isNewLocal(pc int, s int) bool {
+	v := l.p.Var(pc+1, s)
+	return v != nil && v.Type == 0 && (v.StartPC == uint64(pc+1) || v.StartPC == uint64(pc+2)) && isLuaIdent(v.Name)
+}
+
+func (l *lifter) isTemp(pc int, s int) bool {
+	v := l.p.Var(pc+1, s)
+	return v == nil || v.Type != 0
+}
+
+func (l *lifter) use(pc int, s int) expr {
+	for i, ps := range l.pending {
+		if len(ps.slots) == 1 && ps.slots[0] == s {
+			l.pending = append(l.pending[:i], l.pending[i+1:]...)
+			l.consumed[s] = ps.e
+			return ps.e
+		}
+	}
+	if e, ok := l.consumed[s]; ok {
+		return e
+	}
+	return expr{s: l.slotName(pc, s), kind: exprName, reads: []int{s}}
+}
+
+func (l *lifter) flush() {
+	pending := l.pending
+	l.pending = nil
+	for _, ps := range pending {
+		var names []string
+		for _, s := range ps.slots {
+			names = append(names, fmt.Sprintf("r%d", s))
+		}
+		l.emit(strings.Join(names, ", ") + " = " + ps.e.s)
+	}
+}
+
+// pending expressions reading or writing the slots need to be emitted before
+// the slots are written
+func (l *lifter) written(slots ...int) {
+	for _, s := range slots {
+		delete(l.consumed, s)
+	}
+	for _, ps := range l.pending {
+		for _, s := range slots {
+			for _, r := range append(append([]int{}, ps.slots...), ps.e.reads...) {
+				if r == s {
+					l.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *lifter) assign(pc int, e expr, slots ...int) {
+	l.written(slots...)
+
+	temp := l.fold
+	for _, s := range slots {
+		temp = temp && l.isTemp(pc, s)
+	}
+	if temp {
+		l.pending = append(l.pending, pendingSlots{slots: slots, e: e})
+		return
+	}
+
+	l.flush()
+	var names []string
+	for _, s := range slots {
+		names = append(names, l.dstName(pc, s))
+	}
+	local := ""
+	if l.isNewLocal(pc, slots[0]) {
+		local = "local "
+	}
+	if e.s == "nil" && local != "" {
+		l.emit(local + strings.Join(names, ", "))
+		return
+	}
+	l.emit(local + strings.Join(names, ", ") + " = " + e.s)
+}
+
+func (l *lifter) statement(text string) {
+	l.flush()
+	l.emit(text)
+}
+
+func (l *lifter) str(d int) expr {
+	return luaKGC(l.p.KGCByD(d))
+}
+
+func (l *lifter) uvName(i int) string {
+	if i < len(l.p.UVNames) && isLuaIdent(l.p.UVNames[i]) {
+		return l.p.UVNames[i]
+	}
+	return fmt.Sprintf("uv%d", i)
+}
+
+func (l *lifter) index(t expr, k expr) expr {
+	if strings.HasPrefix(k.s, `"`) {
+		if s, err := strconv.Unquote(k.s); err == nil && isLuaIdent(s) {
+			return expr{s: t.prefix() + "." + s, kind: exprName, reads: t.reads}
+		}
+	}
+	return expr{s: t.prefix() + "[" + k.s + "]", kind: exprName, reads: append(append([]int{}, t.reads...), k.reads...)}
+}
+
+func (l *lifter) global(d int) expr {
+	k := l.p.KGCByD(d)
+	if k != nil && k.Type == kgcStr && isLuaIdent(k.Str) {
+		return expr{s: k.Str, kind: exprName}
+	}
+	return l.index(expr{s: "_G", kind: exprName}, l.str(d))
+}
+
+func (l *lifter) slots(pc int, from int, n int) []expr {
+	var es []expr
+	for s := from; s < from+n; s++ {
+		es = append(es, l.use(pc, s))
+	}
+	return es
+}
+
+func joinExprs(es []expr) expr {
+	var ss []string
+	var reads []int
+	for _, e := range es {
+		ss = append(ss, e.s)
+		reads = append(reads, e.reads...)
+	}
+	return expr{s: strings.Join(ss, ", "), kind: exprConst, prec: precOr, reads: reads}
+}
+
+func (l *lifter) call(pc int, base int, nargs int, multres bool) expr {
+	args := l.slots(pc, base+1+l.fr2, nargs)
+	fn := l.use(pc, base)
+	if multres {
+		args = append(args, l.multres)
+	}
+	// obj:m(...) is a lookup of m in obj with obj as first argument
+	if len(args) > 0 && strings.HasPrefix(fn.s, args[0].prefix()+".") {
+		m := strings.TrimPrefix(fn.s, args[0].prefix()+".")
+		if isLuaIdent(m) {
+			return expr{s: args[0].prefix() + ":" + m + "(" + joinExprs(args[1:]).s + ")", kind: exprName, reads: joinExprs(args).reads}
+		}
+	}
+	a := joinExprs(args)
+	return expr{s: fn.prefix() + "(" + a.s + ")", kind: exprName, reads: append(fn.reads, a.reads...)}
+}
+
+func (l *lifter) results(pc int, base int, n int, e expr) {
+	switch {
+	case n < 0:
+		l.multres = e
+	case n == 0:
+		l.statement(e.s)
+	default:
+		var slots []int
+		for s := base; s < base+n; s++ {
+			slots = append(slots, s)
+		}
+		l.assign(pc, e, slots...)
+	}
+}
+
+func (l *lifter) function(child *Proto) expr {
+	cl := newLifter(l.dump, child)
+	cl.indent = 1
+	cl.lift(0, len(child.Ins), &liftCtx{brk: -1, head: -1, end: -1})
+	cl.flush()
+	var sb strings.Builder
+	sb.WriteString("function(" + cl.params() + ")\n")
+	sb.WriteString(cl.String())
+	sb.WriteString("end")
+	return expr{s: sb.String(), kind: exprConst}
+}
+
+func (l *lifter) params() string {
+	var ps []string
+	for s := 0; s < int(l.p.NumParams); s++ {
+		ps = append(ps, l.slotName(0, s))
+	}
+	if l.p.Vararg() {
+		ps = append(ps, "...")
+	}
+	return strings.Join(ps, ", ")
+}
+
+// condition of a test instruction, jump is when the following jump is
+// taken, fall the negation
+func (l *lifter) cond(pc int) (jump expr, fall expr, ok bool) {
+	ins := l.p.Ins[pc]
+	a, d := int(ins.A), int(ins.D)
+	cmp := func(op string, nop string, b expr) (expr, expr, bool) {
+		e := binExpr(l.use(pc, a), op, b)
+		if nop != "" {
+			return e, binExpr(l.use(pc, a), nop, b), true
+		}
+		return e, notExpr(e), true
+	}
+
+	switch l.opName(pc) {
+	case "ISLT":
+		return cmp("<", "", l.use(pc, d))
+	case "ISGE":
+		return cmp(">=", "", l.use(pc, d))
+	case "ISLE":
+		return cmp("<=", "", l.use(pc, d))
+	case "ISGT":
+		return cmp(">", "", l.use(pc, d))
+	case "ISEQV":
+		return cmp("==", "~=", l.use(pc, d))
+	case "ISNEV":
+		return cmp("~=", "==", l.use(pc, d))
+	case "ISEQS":
+		return cmp("==", "~=", l.str(d))
+	case "ISNES":
+		return cmp("~=", "==", l.str(d))
+	case "ISEQN":
+		return cmp("==", "~=", luaKNum(l.p.KNumByD(d)))
+	case "ISNEN":
+		return cmp("~=", "==", luaKNum(l.p.KNumByD(d)))
+	case "ISEQP":
+		return cmp("==", "~=", luaPri(d))
+	case "ISNEP":
+		return cmp("~=", "==", luaPri(d))
+	case "IST":
+		e := l.use(pc, d)
+		return e, notExpr(e), true
+	case "ISF":
+		e := l.use(pc, d)
+		return notExpr(e), e, true
+	case "ISTC", "ISFC":
+		// copy and test, emit the copy so the condition can use it
+		e := l.use(pc, d)
+		l.written(a)
+		l.flush()
+		l.emit(l.dstName(pc, a) + " = " + e.s)
+		c := expr{s: l.dstName(pc, a), kind: exprName}
+		if l.opName(pc) == "ISTC" {
+			return c, notExpr(c), true
+		}
+		return notExpr(c), c, true
+	}
+	return expr{}, expr{}, false
+}
+
+func (l *lifter) block(from int, to int, ctx *liftCtx) {
+	l.flush()
+	l.indent++
+	l.lift(from, to, ctx)
+	l.flush()
+	l.indent--
+}
+
+func (l *lifter) jump(pc int, t int, ctx *liftCtx) {
+	switch {
+	case t == pc+1:
+	case t == ctx.brk:
+		l.statement("break")
+	case t == ctx.head && pc == ctx.end:
+	default:
+		l.gotos[t] = true
+		l.statement(fmt.Sprintf("goto L%d", t))
+	}
+}
+
+func (l *lifter) loop(pc int, h int, ctx *liftCtx) {
+	l.flush()
+	start := len(l.lines)
+	inner := &liftCtx{brk: h + 1, head: pc, end: h}
+	l.block(pc, h+1, inner)
+	body := append([]luaLine{}, l.lines[start:]...)
+	l.lines = l.lines[:start]
+
+	if inner.until != nil {
+		l.emit("repeat")
+		l.lines = append(l.lines, body...)
+		l.emit("until " + inner.until.s)
+		return
+	}
+
+	// while <cond> do if the loop starts with a conditional break
+	first := 0
+	for first < len(body) && body[first].label != -1 && !l.gotos[body[first].label] {
+		first++
+	}
+	if first < len(body) && body[first].breakFall != nil && body[first].indent == l.indent+1 {
+		l.emit("while " + body[first].breakFall.s + " do")
+		l.lines = append(l.lines, body[:first]...)
+		l.lines = append(l.lines, body[first+1:]...)
+		l.emit("end")
+		return
+	}
+
+	l.emit("while true do")
+	l.lines = append(l.lines, body...)
+	l.emit("end")
+}
+
+func (l *lifter) lift(from int, to int, ctx *liftCtx) {
+	for pc := from; pc < to; {
+		if l.targets[pc] {
+			l.flush()
+			l.consumed = map[int]expr{}
+			l.lines = append(l.lines, luaLine{indent: l.indent - 1, text: fmt.Sprintf("::L%d::", pc), label: pc})
+		}
+
+		if h, ok := l.loops[pc]; ok && pc != ctx.head && h < to {
+			l.loop(pc, h, ctx)
+			pc = h + 1
+			continue
+		}
+
+		pc = l.liftIns(pc, to, ctx)
+	}
+}
+
+// slot ranges LuaJIT never writes, ex from a crafted dump
+func validOperands(name string, a, b, c, d int) bool {
+	switch name {
+	case "CAT":
+		return b <= c
+	case "KNIL":
+		return a <= d
+	case "TSETM":
+		return a >= 1
+	}
+	return true
+}
+
+// lift instruction at pc, returns index of next instruction to lift
+func (l *lifter) liftIns(pc int, to int, ctx *liftCtx) int {
+	ins := l.p.Ins[pc]
+	a, b, c, d := int(ins.A), int(ins.B), int(ins.C), int(ins.D)
+	name := l.opName(pc)
+
+	arith := map[string]string{"ADD": "+", "SUB": "-", "MUL": "*", "DIV": "/", "MOD": "%"}
+
+	if !validOperands(name, a, b, c, d) {
+		if l.dump.Opcodes.Get(int(ins.Op)).HasD() {
+			l.statement(fmt.Sprintf("-- %s %d %d", name, a, d))
+		} else {
+			l.statement(fmt.Sprintf("-- %s %d %d %d", name, a, b, c))
+		}
+		return pc + 1
+	}
+
+	switch {
+	case strings.HasPrefix(name, "IS") && name != "ISNEXT" && name != "ISTYPE" && name != "ISNUM":
+		jump, fall, ok := l.cond(pc)
+		if !ok || l.opName(pc+1) != "JMP" {
+			l.statement(fmt.Sprintf("-- %s %d %d", name, a, d))
+			return pc + 1
+		}
+		t := l.p.Ins[pc+1].Target(pc + 1)
+		l.flush()
+		switch {
+		case t == ctx.brk:
+			l.lines = append(l.lines, luaLine{indent: l.indent, text: "if " + jump.s + " then break end", label: -1, breakFall: &fall})
+			return pc + 2
+		case pc+1 == ctx.end && t == ctx.head:
+			ctx.until = &fall
+			return pc + 2
+		case t > pc+1 && t <= to:
+			// else branch if the if body ends with a forward jump past the else
+			if t-1 > pc+1 && l.opName(t-1) == "JMP" {
+				e := l.p.Ins[t-1].Target(t - 1)
+				if e > t && e <= to && e != ctx.brk {
+					l.emit("if " + fall.s + " then")
+					l.block(pc+2, t-1, ctx)
+					l.emit("else")
+					l.block(t, e, ctx)
+					l.emit("end")
+					return e
+				}
+			}
+			l.emit("if " + fall.s + " then")
+			l.block(pc+2, t, ctx)
+			l.emit("end")
+			return t
+		default:
+			l.gotos[t] = true
+			l.emit(fmt.Sprintf("if %s then goto L%d end", jump.s, t))
+			return pc + 2
+		}
+
+	case name == "MOV":
+		l.assign(pc, l.use(pc, d), a)
+	case name == "NOT":
+		l.assign(pc, notExpr(l.use(pc, d)), a)
+	case name == "UNM":
+		l.assign(pc, unaryExpr("-", l.use(pc, d)), a)
+	case name == "LEN":
+		l.assign(pc, unaryExpr("#", l.use(pc, d)), a)
+	case len(name) == 5 && arith[name[0:3]] != "":
+		op := arith[name[0:3]]
+		switch name[3:] {
+		case "VN":
+			l.assign(pc, binExpr(l.use(pc, b), op, luaKNum(l.p.KNumByD(c))), a)
+		case "NV":
+			l.assign(pc, binExpr(luaKNum(l.p.KNumByD(c)), op, l.use(pc, b)), a)
+		default:
+			vb := l.use(pc, b)
+			l.assign(pc, binExpr(vb, op, l.use(pc, c)), a)
+		}
+	case name == "POW":
+		vb := l.use(pc, b)
+		l.assign(pc, binExpr(vb, "^", l.use(pc, c)), a)
+	case name == "CAT":
+		es := l.slots(pc, b, c-b+1)
+		e := es[len(es)-1]
+		for i := len(es) - 2; i >= 0; i-- {
+			e = binExpr(es[i], "..", e)
+		}
+		l.assign(pc, e, a)
+
+	case name == "KSTR", name == "KCDATA":
+		l.assign(pc, l.str(d), a)
+	case name == "KSHORT":
+		l.assign(pc, luaInt(int64(int16(ins.D))), a)
+	case name == "KNUM":
+		l.assign(pc, luaKNum(l.p.KNumByD(d)), a)
+	case name == "KPRI":
+		l.assign(pc, luaPri(d), a)
+	case name == "KNIL":
+		var slots []int
+		for s := a; s <= d; s++ {
+			slots = append(slots, s)
+		}
+		l.assign(pc, luaPri(0), slots...)
+
+	case name == "UGET":
+		l.assign(pc, expr{s: l.uvName(d), kind: exprName}, a)
+	case name == "USETV":
+		l.statement(l.uvName(a) + " = " + l.use(pc, d).s)
+	case name == "USETS":
+		l.statement(l.uvName(a) + " = " + l.str(d).s)
+	case name == "USETN":
+		l.statement(l.uvName(a) + " = " + luaKNum(l.p.KNumByD(d)).s)
+	case name == "USETP":
+		l.statement(l.uvName(a) + " = " + luaPri(d).s)
+	case name == "UCLO":
+		l.jump(pc, ins.Target(pc), ctx)
+
+	case name == "FNEW":
+		k := l.p.KGCByD(d)
+		if k == nil || k.Child == nil {
+			l.assign(pc, luaKGC(k), a)
+			break
+		}
+		f := l.function(k.Child)
+		if v := l.p.Var(pc, a); v != nil && v.StartPC == uint64(pc+1) && isLuaIdent(v.Name) {
+			// local function f() is visible to itself
+			l.statement("local function " + v.Name + strings.TrimPrefix(f.s, "function"))
+			break
+		}
+		l.assign(pc, f, a)
+	case name == "TNEW":
+		l.assign(pc, expr{s: "{}", kind: exprConst}, a)
+	case name == "TDUP":
+		l.assign(pc, l.str(d), a)
+	case name == "GGET":
+		l.assign(pc, l.global(d), a)
+	case name == "GSET":
+		e := l.use(pc, a)
+		l.statement(l.global(d).s + " = " + e.s)
+	case name == "TGETV", name == "TGETR":
+		t := l.use(pc, b)
+		l.assign(pc, l.index(t, l.use(pc, c)), a)
+	case name == "TGETS":
+		l.assign(pc, l.index(l.use(pc, b), l.str(c)), a)
+	case name == "TGETB":
+		l.assign(pc, l.index(l.use(pc, b), luaInt(int64(c))), a)
+	case name == "TSETV", name == "TSETR":
+		v := l.use(pc, a)
+		t := l.use(pc, b)
+		l.statement(l.index(t, l.use(pc, c)).s + " = " + v.s)
+	case name == "TSETS":
+		v := l.use(pc, a)
+		l.statement(l.index(l.use(pc, b), l.str(c)).s + " = " + v.s)
+	case name == "TSETB":
+		v := l.use(pc, a)
+		l.statement(l.index(l.use(pc, b), luaInt(int64(c))).s + " = " + v.s)
+	case name == "TSETM":
+		// D is a number constant with the first index in the low 32 bits
+		var idx int64
+		if k := l.p.KNumByD(d); k != nil && !k.IsInt {
+			idx = int64(int32(math.Float64bits(k.Num)))
+		}
+		t := l.use(pc, a-1)
+		l.statement(fmt.Sprintf("%s[%d...] = %s", t.prefix(), idx, l.multres.s))
+
+	case name == "CALL", name == "CALLM":
+		l.results(pc, a, b-1, l.call(pc, a, c-1+boolInt(name == "CALLM"), name == "CALLM"))
+	case name == "CALLT", name == "CALLMT":
+		l.statement("return " + l.call(pc, a, d-1+boolInt(name == "CALLMT"), name == "CALLMT").s)
+	case name == "VARG":
+		l.results(pc, a, b-1, expr{s: "...", kind: exprConst})
+
+	case name == "RET0":
+		if pc != len(l.p.Ins)-1 {
+			l.statement("return")
+		}
+	case name == "RET1":
+		l.statement("return " + l.use(pc, a).s)
+	case name == "RET":
+		l.statement("return " + joinExprs(l.slots(pc, a, d-1)).s)
+	case name == "RETM":
+		l.statement("return " + joinExprs(append(l.slots(pc, a, d), l.multres)).s)
+
+	case name == "FORI", name == "JFORI":
+		t := ins.Target(pc)
+		if t-1 <= pc || t > to || !strings.HasSuffix(l.opName(t-1), "FORL") {
+			l.statement(fmt.Sprintf("-- %s %d %d", name, a, d))
+			break
+		}
+		es := l.slots(pc, a, 3)
+		if es[2].s == "1" {
+			es = es[:2]
+		}
+		l.flush()
+		l.emit(fmt.Sprintf("for %s = %s do", l.dstName(pc, a+3), joinExprs(es).s))
+		l.block(pc+1, t-1, &liftCtx{brk: t, head: -1, end: -1})
+		l.emit("end")
+		return t
+
+	case name == "JMP", name == "ISNEXT":
+		t := ins.Target(pc)
+		iter := l.opName(t)
+		if t > pc && t+1 < to && (iter == "ITERC" || iter == "ITERN") &&
+			strings.HasSuffix(l.opName(t+1), "ITERL") && l.p.Ins[t+1].Target(t+1) == pc+1 {
+			l.forIn(pc, t, ctx)
+			return t + 2
+		}
+		l.jump(pc, t, ctx)
+
+	case name == "LOOP", name == "ILOOP", name == "JLOOP":
+	case name == "ISTYPE", name == "ISNUM":
+		// type checks emitted for typed locals, no source equivalent
+
+	default:
+		l.statement(fmt.Sprintf("-- %s %d %d %d", name, a, b, c))
+	}
+
+	return pc + 1
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// generic for, ITERC at t calls A-3 with A-2, A-1 and assigns B-1 results from A
+func (l *lifter) forIn(pc int, t int, ctx *liftCtx) {
+	ins := l.p.Ins[t]
+	a, nvars := int(ins.A), int(ins.B)-1
+
+	var iter []expr
+	for i, ps := range l.pending {
+		if len(ps.slots) == 3 && ps.slots[0] == a-3 {
+			l.pending = append(l.pending[:i], l.pending[i+1:]...)
+			iter = []expr{ps.e}
+			break
+		}
+	}
+	if iter == nil {
+		iter = l.slots(pc, a-3, 3)
+		for len(iter) > 1 && iter[len(iter)-1].s == "nil" {
+			iter = iter[:len(iter)-1]
+		}
+	}
+
+	var vars []string
+	for s := a; s < a+nvars; s++ {
+		vars = append(vars, l.dstName(pc, s))
+	}
+
+	l.flush()
+	l.emit(fmt.Sprintf("for %s in %s do", strings.Join(vars, ", "), joinExprs(iter).s))
+	l.block(pc+1, t, &liftCtx{brk: t + 2, head: -1, end: -1})
+	l.emit("end")
+}
+
+func (l *lifter) String() string {
+	var sb strings.Builder
+	for _, line := range l.lines {
+		if line.label != -1 && !l.gotos[line.label] {
+			continue
+		}
+		indent := ""
+		if line.indent > 0 {
+			indent = strings.Repeat("  ", line.indent)
+		}
+		for i, s := range strings.Split(line.text, "\n") {
+			if i > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(indent + s)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Decompile lifts the main chunk and all functions defined in it to pseudo-Lua
+func Decompile(dump *Dump) string {
+	main := dump.Main()
+	if main == nil {
+		return ""
+	}
+	l := newLifter(dump, main)
+	l.lift(0, len(main.Ins), &liftCtx{brk: -1, head: -1, end: -1})
+	l.flush()
+	return l.String()
+}
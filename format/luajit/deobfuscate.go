@@ -0,0 +1,250 @@
+package luajit
+
+// strings built when the chunk runs from constants only, the usual way
+// obfuscators hide strings: string.char chains, concatenation of constants and
+// lookups in constant tables
+
+import (
+	"math"
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_deobfuscate_strings", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return DeobfuscateStrings(dump)
+	})
+}
+
+// known value of a slot, a string or number constant, a global or field path
+// like "string.char" or a table constant. site is the pc of the instruction
+// that built a string, -1 for constants
+type constVal struct {
+	str  *string
+	num  *float64
+	path string
+	tab  *KTab
+	site int
+}
+
+func constStr(s string, site int) constVal { return constVal{str: &s, site: site} }
+func constNum(f float64) constVal          { return constVal{num: &f, site: -1} }
+
+// string as concatenation converts it, numbers like tostring
+func (v constVal) text() (string, bool) {
+	switch {
+	case v.str != nil:
+		return *v.str, true
+	case v.num != nil:
+		return luaNumString(*v.num), true
+	}
+	return "", false
+}
+
+func ktabConst(k KTabK) (constVal, bool) {
+	switch k.Type {
+	case ktabInt:
+		return constNum(float64(k.Int)), true
+	case ktabNum:
+		return constNum(k.Num), true
+	case ktabStr:
+		return constStr(k.Str, -1), true
+	}
+	return constVal{}, false
+}
+
+// value of t[key], array part for integer keys and then the hash part
+func ktabLookup(t *KTab, key constVal) (constVal, bool) {
+	if key.num != nil {
+		if f := *key.num; f >= 0 && f < float64(len(t.Array)) && f == math.Trunc(f) {
+			return ktabConst(t.Array[int(f)])
+		}
+	}
+	for _, kv := range t.Hash {
+		k, ok := ktabConst(kv[0])
+		switch {
+		case !ok:
+		case k.num != nil && key.num != nil && *k.num == *key.num,
+			k.str != nil && key.str != nil && *k.str == *key.str:
+			return ktabConst(kv[1])
+		}
+	}
+	return constVal{}, false
+}
+
+// DeobfuscateStrings evaluates constant only string.char calls, CAT and
+// table constant lookups and lists the strings they build with proto, pc
+// and how it was built. Slots are followed linearly and forgotten at the
+// start of basic blocks. Strings used to build a longer one are only part of
+// the longer one
+func DeobfuscateStrings(dump *Dump) []any {
+	type site struct {
+		pc     int
+		kind   string
+		value  string
+		merged bool
+	}
+	found := []any{}
+	for _, p := range dump.Protos {
+		leaders := map[int]bool{}
+		for _, b := range dump.Blocks(p) {
+			leaders[b.Start] = true
+		}
+		slots := map[int]constVal{}
+		sites := map[int]*site{}
+		// a string built at pc that is used to build another one
+		use := func(v constVal) {
+			if s, ok := sites[v.site]; ok && v.site >= 0 {
+				s.merged = true
+			}
+		}
+		build := func(pc int, kind string, a int, s string) {
+			sites[pc] = &site{pc: pc, kind: kind, value: s}
+			slots[a] = constStr(s, pc)
+		}
+
+		for pc, ins := range p.Ins {
+			if leaders[pc] {
+				slots = map[int]constVal{}
+			}
+			a := int(ins.A)
+			var v constVal
+			known := false
+			switch name := dump.OpName(p, pc); name {
+			case "KSTR":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr {
+					v, known = constStr(k.Str, -1), true
+				}
+			case "KSHORT":
+				v, known = constNum(float64(int16(ins.D))), true
+			case "KNUM":
+				if k := p.KNumByD(int(ins.D)); k != nil {
+					if k.IsInt {
+						v, known = constNum(float64(k.Int)), true
+					} else {
+						v, known = constNum(k.Num), true
+					}
+				}
+			case "TDUP":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcTab {
+					v, known = constVal{tab: k.Tab, site: -1}, true
+				}
+			case "GGET":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr {
+					v, known = constVal{path: k.Str, site: -1}, true
+				}
+			case "UGET":
+				if int(ins.D) < len(p.UVNames) && p.UVNames[ins.D] == "string" {
+					v, known = constVal{path: "string", site: -1}, true
+				}
+			case "MOV":
+				v, known = slots[int(ins.D)]
+			case "TGETS", "TGETB", "TGETV":
+				t := slots[int(ins.B)]
+				var key constVal
+				switch name {
+				case "TGETS":
+					if k := p.KGCByD(int(ins.C)); k != nil && k.Type == kgcStr {
+						key = constStr(k.Str, -1)
+					}
+				case "TGETB":
+					key = constNum(float64(ins.C))
+				default:
+					key = slots[int(ins.C)]
+				}
+				switch {
+				case t.path != "" && key.str != nil:
+					v, known = constVal{path: t.path + "." + *key.str, site: -1}, true
+				case t.tab != nil:
+					if e, ok := ktabLookup(t.tab, key); ok && e.str != nil {
+						build(pc, "table", a, *e.str)
+						continue
+					} else if ok {
+						v, known = e, true
+					}
+				}
+			case "CAT":
+				var s string
+				// at least one operand, all of them known
+				ok := ins.B <= ins.C
+				for i := int(ins.B); i <= int(ins.C) && ok; i++ {
+					var t string
+					t, ok = slots[i].text()
+					s += t
+				}
+				if ok {
+					for i := int(ins.B); i <= int(ins.C); i++ {
+						use(slots[i])
+					}
+					build(pc, "concat", a, s)
+					continue
+				}
+			case "CALL":
+				// args from A+1, A+2 with fr2, C-1 of them, B-1 results
+				arg := a + 1
+				if dump.FR2() {
+					arg++
+				}
+				var bs []byte
+				ok := slots[a].path == "string.char" && ins.C > 0
+				for i := 0; ok && i < int(ins.C)-1; i++ {
+					n := slots[arg+i].num
+					ok = n != nil && *n >= 0 && *n <= 255 && *n == math.Trunc(*n)
+					if ok {
+						bs = append(bs, byte(*n))
+					}
+				}
+				for s := range slots {
+					if s >= a {
+						delete(slots, s)
+					}
+				}
+				if ok && ins.B != 1 {
+					build(pc, "string.char", a, string(bs))
+				}
+				continue
+			}
+
+			if def := dump.Opcodes.Get(int(ins.Op)); def.MA == BcMbase {
+				for s := range slots {
+					if s >= a {
+						delete(slots, s)
+					}
+				}
+			} else if def.MA == BcMdst {
+				delete(slots, a)
+			}
+			if known {
+				slots[a] = v
+			}
+		}
+
+		var pcs []int
+		for pc, s := range sites {
+			if !s.merged {
+				pcs = append(pcs, pc)
+			}
+		}
+		sort.Ints(pcs)
+		for _, pc := range pcs {
+			s := sites[pc]
+			r := map[string]any{
+				"proto":      p.Index,
+				"proto_name": dump.ProtoName(p),
+				"pc":         pc,
+				"kind":       s.kind,
+				"value":      s.value,
+			}
+			if len(p.LineInfo) == len(p.Ins) {
+				r["line"] = int(p.Line(pc))
+			}
+			found = append(found, r)
+		}
+	}
+	return found
+}
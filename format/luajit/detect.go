@@ -0,0 +1,187 @@
+package luajit
+
+// guess the version from the instructions when the version byte can't be
+// trusted
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_detect_version", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return DetectVersion(dump)
+	})
+}
+
+// max number of problems listed per candidate
+const detectMaxProblems = 5
+
+// problem with instruction pc as decoded with opcodes, "" if it looks like
+// something the LuaJIT parser writes
+func detectProblem(p *Proto, pc int, opcodes BcDefList) string {
+	ins := p.Ins[pc]
+	if int(ins.Op) >= len(opcodes) {
+		return fmt.Sprintf("unknown opcode %d", ins.Op)
+	}
+	def := opcodes.Get(int(ins.Op))
+	// function headers and interpreter or JIT variants only exist at runtime
+	if strings.HasPrefix(def.Name, "FUNC") ||
+		((def.Name[0] == 'I' || def.Name[0] == 'J') && opcodes.index(def.Name[1:]) >= 0) {
+		return fmt.Sprintf("%s is not written by the parser", def.Name)
+	}
+
+	if def.MA == BcMuv && int(ins.A) >= len(p.UV) {
+		return fmt.Sprintf("%s upvalue %d out of range", def.Name, ins.A)
+	}
+	d := int(ins.D)
+	if !def.HasD() {
+		d = int(ins.C)
+	}
+	switch def.MC {
+	case BcMuv:
+		if d >= len(p.UV) {
+			return fmt.Sprintf("%s upvalue %d out of range", def.Name, d)
+		}
+	case BcMnum:
+		if p.KNumByD(d) == nil {
+			return fmt.Sprintf("%s number constant %d out of range", def.Name, d)
+		}
+	case BcMstr, BcMtab, BcMfunc, BcMcdata:
+		want := map[int][]uint64{
+			BcMstr:   {kgcStr},
+			BcMtab:   {kgcTab},
+			BcMfunc:  {kgcChild},
+			BcMcdata: {kgcI64, kgcU64, kgcComplex},
+		}[def.MC]
+		k := p.KGCByD(d)
+		if k == nil {
+			return fmt.Sprintf("%s constant %d out of range", def.Name, d)
+		}
+		t := k.Type
+		if t > kgcStr {
+			t = kgcStr
+		}
+		found := false
+		for _, w := range want {
+			found = found || t == w
+		}
+		if !found {
+			return fmt.Sprintf("%s constant %d is a %s", def.Name, d, kgcKinds[t])
+		}
+	case BcMjump:
+		if t := ins.Target(pc); t < 0 || t > len(p.Ins) {
+			return fmt.Sprintf("%s target %d out of range", def.Name, t)
+		}
+	}
+	return ""
+}
+
+// index of opcode name, -1 if not found
+func (opcodes BcDefList) index(name string) int {
+	for i, def := range opcodes {
+		if def.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// DetectVersion checks the instructions with the opcode table of each version
+// and scores it by the fraction that look like parser output, ex constant
+// operands of the right type and jumps in range. Header flags and constants
+// add hints about the build
+func DetectVersion(dump *Dump) map[string]any {
+	versions := []uint64{versionLuaJIT20, versionLuaJIT21}
+
+	var candidates []map[string]any
+	for _, v := range versions {
+		opcodes := versionOpcodes[v]
+		total := 0
+		invalid := 0
+		problems := []any{}
+		for _, p := range dump.Protos {
+			for pc := range p.Ins {
+				total++
+				problem := detectProblem(p, pc, opcodes)
+				if problem == "" {
+					continue
+				}
+				invalid++
+				if len(problems) < detectMaxProblems {
+					problems = append(problems, fmt.Sprintf("proto %d pc %d: %s", p.Index, pc, problem))
+				}
+			}
+		}
+		confidence := 0.0
+		if total > 0 {
+			confidence = float64(total-invalid) / float64(total)
+		}
+		// only 2.1 has the FR2 flag
+		if dump.FR2() && v != versionLuaJIT21 {
+			confidence = 0
+			problems = append(problems, "FR2 flag only used by 2.1")
+		}
+		candidates = append(candidates, map[string]any{
+			"version":    versionMap[v].Sym,
+			"confidence": confidence,
+			"invalid":    invalid,
+			"problems":   problems,
+		})
+	}
+	// stable so the header version wins a tie, otherwise the latest version
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i]["confidence"].(float64), candidates[j]["confidence"].(float64)
+		if ci != cj {
+			return ci > cj
+		}
+		if hv, ok := versionMap[dump.Version]; ok {
+			return candidates[i]["version"] == hv.Sym
+		}
+		return candidates[i]["version"].(string) > candidates[j]["version"].(string)
+	})
+
+	hints := []any{}
+	if dump.FR2() {
+		hints = append(hints, "FR2 flag, 2.1 built with LJ_GC64")
+	}
+	integralFloats := 0
+	overlong := dump.OverlongULEB
+	for _, p := range dump.Protos {
+		for _, k := range p.KNum {
+			if !k.IsInt && integralFloat(k.Num) {
+				integralFloats++
+			}
+		}
+		overlong += p.OverlongULEB
+	}
+	if integralFloats > 0 {
+		hints = append(hints, fmt.Sprintf("%d integral float constants, DUALNUM build", integralFloats))
+	}
+	if overlong > 0 {
+		hints = append(hints, fmt.Sprintf("%d overlong ULEB128, not written by LuaJIT", overlong))
+	}
+
+	var cs []any
+	for _, c := range candidates {
+		cs = append(cs, c)
+	}
+	var headerVersion any
+	if hv, ok := versionMap[dump.Version]; ok {
+		headerVersion = hv.Sym
+	}
+	return map[string]any{
+		"header_version": headerVersion,
+		"version":        candidates[0]["version"],
+		"confidence":     candidates[0]["confidence"],
+		"candidates":     cs,
+		"hints":          hints,
+	}
+}
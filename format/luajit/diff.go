@@ -0,0 +1,130 @@
+package luajit
+
+// structural diff of two dumps, compares the parsed model so layout only
+// differences like ULEB128 encoding width are ignored
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc1("luajit_diff", func(_ *interp.Interp, c any, other any) any {
+		a, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		b, err := toDump(other)
+		if err != nil {
+			return err
+		}
+		return Diff(a, b)
+	})
+}
+
+type differ struct {
+	changes []any
+}
+
+// a or b is nil if missing on that side, values are int or string
+func (df *differ) add(path string, a any, b any) {
+	df.changes = append(df.changes, map[string]any{"path": path, "a": a, "b": b})
+}
+
+func (df *differ) cmp(path string, a any, b any) {
+	if a != b {
+		df.add(path, a, b)
+	}
+}
+
+// compare lists of length na and nb using string representation of items
+func (df *differ) list(path string, na int, nb int, a func(i int) string, b func(i int) string) {
+	n := na
+	if nb > n {
+		n = nb
+	}
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= na:
+			df.add(p, nil, b(i))
+		case i >= nb:
+			df.add(p, a(i), nil)
+		default:
+			df.cmp(p, a(i), b(i))
+		}
+	}
+}
+
+func varInfoString(v VarInfo) string {
+	name := v.Name
+	if v.Type != 0 {
+		name = varInfoInternalNames[v.Type]
+	}
+	return fmt.Sprintf("%s %d-%d", name, v.StartPC, v.EndPC)
+}
+
+// Diff compares dump a and b proto by proto, changes have a path, ex
+// "proto[1].ins[3]", and the value in a and b as text
+func Diff(a *Dump, b *Dump) map[string]any {
+	df := &differ{changes: []any{}}
+
+	df.cmp("header.version", int(a.Version), int(b.Version))
+	df.cmp("header.flags", int(a.Flags), int(b.Flags))
+	df.cmp("header.name", a.Name, b.Name)
+
+	// only missing protos, names depend on debug info
+	df.list("proto", len(a.Protos), len(b.Protos),
+		func(i int) string { return fmt.Sprintf("proto %d", i) },
+		func(i int) string { return fmt.Sprintf("proto %d", i) },
+	)
+
+	for i := 0; i < len(a.Protos) && i < len(b.Protos); i++ {
+		pa := a.Protos[i]
+		pb := b.Protos[i]
+		path := fmt.Sprintf("proto[%d]", i)
+
+		df.cmp(path+".flags", int(pa.Flags), int(pb.Flags))
+		df.cmp(path+".numparams", int(pa.NumParams), int(pb.NumParams))
+		df.cmp(path+".framesize", int(pa.FrameSize), int(pb.FrameSize))
+		// instruction text includes pc which is the same for both
+		df.list(path+".ins", len(pa.Ins), len(pb.Ins),
+			func(pc int) string { return a.InsString(pa, pc) },
+			func(pc int) string { return b.InsString(pb, pc) },
+		)
+		df.list(path+".uv", len(pa.UV), len(pb.UV),
+			func(i int) string { return fmt.Sprintf("0x%04x", pa.UV[i]) },
+			func(i int) string { return fmt.Sprintf("0x%04x", pb.UV[i]) },
+		)
+		// in operand order, same as the D operand of instructions
+		df.list(path+".kgc", len(pa.KGC), len(pb.KGC),
+			func(d int) string { return luaKGC(pa.KGCByD(d)).s },
+			func(d int) string { return luaKGC(pb.KGCByD(d)).s },
+		)
+		df.list(path+".knum", len(pa.KNum), len(pb.KNum),
+			func(d int) string { return luaKNum(pa.KNumByD(d)).s },
+			func(d int) string { return luaKNum(pb.KNumByD(d)).s },
+		)
+
+		df.cmp(path+".firstline", int(pa.FirstLine), int(pb.FirstLine))
+		df.cmp(path+".numline", int(pa.NumLine), int(pb.NumLine))
+		df.list(path+".lineinfo", len(pa.LineInfo), len(pb.LineInfo),
+			func(i int) string { return fmt.Sprint(pa.LineInfo[i]) },
+			func(i int) string { return fmt.Sprint(pb.LineInfo[i]) },
+		)
+		df.list(path+".uvnames", len(pa.UVNames), len(pb.UVNames),
+			func(i int) string { return pa.UVNames[i] },
+			func(i int) string { return pb.UVNames[i] },
+		)
+		df.list(path+".varinfo", len(pa.VarInfo), len(pb.VarInfo),
+			func(i int) string { return varInfoString(pa.VarInfo[i]) },
+			func(i int) string { return varInfoString(pb.VarInfo[i]) },
+		)
+	}
+
+	return map[string]any{
+		"equal":   len(df.changes) == 0,
+		"changes": df.changes,
+	}
+}
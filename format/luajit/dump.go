@@ -0,0 +1,612 @@
+package luajit
+
+// plain go model of a dump, used by functions that work on the whole dump
+// instead of the decoded value tree (decompiler, graphs etc)
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
+)
+
+const (
+	dumpFlagBE    = 0x1
+	dumpFlagStrip = 0x2
+	dumpFlagFFI   = 0x4
+	dumpFlagFR2   = 0x8
+
+	dumpFlagsKnown = dumpFlagBE | dumpFlagStrip | dumpFlagFFI | dumpFlagFR2
+
+	protoFlagChild  = 0x1
+	protoFlagVararg = 0x2
+	protoFlagFFI    = 0x4
+)
+
+const (
+	kgcChild   = 0
+	kgcTab     = 1
+	kgcI64     = 2
+	kgcU64     = 3
+	kgcComplex = 4
+	kgcStr     = 5
+)
+
+const (
+	ktabNil   = 0
+	ktabFalse = 1
+	ktabTrue  = 2
+	ktabInt   = 3
+	ktabNum   = 4
+	ktabStr   = 5
+)
+
+type Dump struct {
+	Version uint64
+	Flags   uint64
+	Name    string
+	// bytes before the signature, ex a shebang line
+	Offset int
+	// in header
+	OverlongULEB int
+	// in dump order, children before parents and the main chunk last
+	Protos  []*Proto
+	Opcodes BcDefList
+}
+
+func (dump *Dump) BigEndian() bool { return dump.Flags&dumpFlagBE != 0 }
+func (dump *Dump) Strip() bool     { return dump.Flags&dumpFlagStrip != 0 }
+func (dump *Dump) FR2() bool       { return dump.Flags&dumpFlagFR2 != 0 }
+
+func (dump *Dump) Main() *Proto {
+	if len(dump.Protos) == 0 {
+		return nil
+	}
+	return dump.Protos[len(dump.Protos)-1]
+}
+
+type Ins struct {
+	Op uint8
+	A  uint8
+	B  uint8
+	C  uint8
+	D  uint16
+}
+
+// from word as in memory, op | a<<8 | c<<16 | b<<24 or op | a<<8 | d<<16
+func insFromWord(w uint32) Ins {
+	return Ins{
+		Op: uint8(w),
+		A:  uint8(w >> 8),
+		C:  uint8(w >> 16),
+		B:  uint8(w >> 24),
+		D:  uint16(w >> 16),
+	}
+}
+
+// jump target as instruction index, pc + 1 + signed D
+func (ins Ins) Target(pc int) int {
+	return pc + 1 + int(ins.D) - 0x8000
+}
+
+type KTabK struct {
+	Type uint64
+	Int  int32
+	Num  float64
+	Str  string
+}
+
+type KTab struct {
+	Array []KTabK
+	Hash  [][2]KTabK
+}
+
+type KGC struct {
+	Type  uint64
+	Str   string
+	Tab   *KTab
+	I64   int64
+	U64   uint64
+	Real  float64
+	Imag  float64
+	Child *Proto
+}
+
+type KNum struct {
+	IsInt bool
+	Int   int32
+	Num   float64
+}
+
+type VarInfo struct {
+	Name    string
+	Type    uint8 // 0 if named, otherwise internal for loop variable type
+	StartPC uint64
+	EndPC   uint64
+}
+
+var varInfoInternalNames = []string{
+	1: "(for idx)",
+	2: "(for stop)",
+	3: "(for step)",
+	4: "(for gen)",
+	5: "(for state)",
+	6: "(for ctl)",
+}
+
+type Proto struct {
+	// index in dump order
+	Index     int
+	Flags     uint8
+	NumParams uint8
+	FrameSize uint8
+	// without the function header instruction which is not part of the dump
+	Ins []Ins
+	UV  []uint16
+	// in dump order which is reversed to the operand order, use KGCByD
+	KGC  []KGC
+	KNum []KNum
+
+	Debug     []byte
+	FirstLine uint64
+	NumLine   uint64
+	LineInfo  []uint64
+	UVNames   []string
+	VarInfo   []VarInfo
+
+	OverlongULEB int
+
+	// byte offsets from the signature of the proto length and first instruction
+	Offset    int
+	InsOffset int
+
+	Parent *Proto
+}
+
+func (p *Proto) Vararg() bool { return p.Flags&protoFlagVararg != 0 }
+
+func (p *Proto) KGCByD(d int) *KGC {
+	i := len(p.KGC) - 1 - d
+	if i < 0 || i >= len(p.KGC) {
+		return nil
+	}
+	return &p.KGC[i]
+}
+
+func (p *Proto) KNumByD(d int) *KNum {
+	if d < 0 || d >= len(p.KNum) {
+		return nil
+	}
+	return &p.KNum[d]
+}
+
+// children in operand order
+func (p *Proto) Children() []*Proto {
+	var ps []*Proto
+	for d := 0; d < len(p.KGC); d++ {
+		if k := p.KGCByD(d); k.Child != nil {
+			ps = append(ps, k.Child)
+		}
+	}
+	return ps
+}
+
+// variable in slot at instruction index pc, nil if unknown
+// varinfo pcs include the function header so instruction index pc is runtime pc+1
+func (p *Proto) Var(pc int, slot int) *VarInfo {
+	rpc := uint64(pc + 1)
+	for i := range p.VarInfo {
+		v := &p.VarInfo[i]
+		if v.StartPC > rpc {
+			break
+		}
+		if rpc < v.EndPC {
+			if slot == 0 {
+				return v
+			}
+			slot--
+		}
+	}
+	return nil
+}
+
+// name of variable in slot at instruction index pc, "" if unknown
+func (p *Proto) VarName(pc int, slot int) string {
+	v := p.Var(pc, slot)
+	switch {
+	case v == nil:
+		return ""
+	case v.Type != 0:
+		return varInfoInternalNames[v.Type]
+	default:
+		return v.Name
+	}
+}
+
+// line of instruction index pc, 0 if unknown
+func (p *Proto) Line(pc int) uint64 {
+	if pc < 0 || pc >= len(p.LineInfo) {
+		return 0
+	}
+	return p.FirstLine + p.LineInfo[pc]
+}
+
+type dumpReader struct {
+	buf []byte
+	pos int
+	err error
+	be  bool
+	// number of ULEB128 with more bytes than needed
+	overlong int
+}
+
+var errDumpShort = errors.New("unexpected end of dump")
+
+func (r *dumpReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		r.err = errDumpShort
+		return nil
+	}
+	bs := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return bs
+}
+
+func (r *dumpReader) u8() uint8 {
+	bs := r.bytes(1)
+	if bs == nil {
+		return 0
+	}
+	return bs[0]
+}
+
+func (r *dumpReader) u16() uint16 {
+	bs := r.bytes(2)
+	if bs == nil {
+		return 0
+	}
+	if r.be {
+		return binary.BigEndian.Uint16(bs)
+	}
+	return binary.LittleEndian.Uint16(bs)
+}
+
+func (r *dumpReader) u32() uint32 {
+	bs := r.bytes(4)
+	if bs == nil {
+		return 0
+	}
+	if r.be {
+		return binary.BigEndian.Uint32(bs)
+	}
+	return binary.LittleEndian.Uint32(bs)
+}
+
+func (r *dumpReader) uleb() uint64 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= uint64(b&0x7f) << shift
+		}
+		if b&0x80 == 0 {
+			// last byte adds nothing, LuaJIT never writes this
+			if shift > 0 && b == 0 {
+				r.overlong++
+			}
+			return v
+		}
+	}
+}
+
+// check that a count read from the dump can fit in what is left
+func (r *dumpReader) count(n uint64, minSize uint64) uint64 {
+	if r.err == nil && n*minSize > uint64(len(r.buf)-r.pos) {
+		r.err = fmt.Errorf("count %d larger than remaining dump", n)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *dumpReader) str(n uint64) string {
+	return string(r.bytes(n))
+}
+
+// 64 bit value of two ULEB128 halves, LuaJIT reads each into 32 bits so
+// higher bits are dropped. Also used by the decoder so both agree
+func joinHalves(lo, hi uint64) uint64 {
+	return uint64(uint32(hi))<<32 | uint64(uint32(lo))
+}
+
+func (r *dumpReader) num() float64 {
+	lo := r.uleb()
+	hi := r.uleb()
+	return math.Float64frombits(joinHalves(lo, hi))
+}
+
+func (r *dumpReader) ktabk() KTabK {
+	k := KTabK{Type: r.uleb()}
+	switch {
+	case k.Type == ktabInt:
+		k.Int = int32(uint32(r.uleb()))
+	case k.Type == ktabNum:
+		k.Num = r.num()
+	case k.Type >= ktabStr:
+		k.Str = r.str(k.Type - ktabStr)
+		k.Type = ktabStr
+	}
+	return k
+}
+
+func (r *dumpReader) kgc() KGC {
+	k := KGC{Type: r.uleb()}
+	switch {
+	case k.Type == kgcTab:
+		t := &KTab{}
+		narray := r.count(r.uleb(), 1)
+		nhash := r.count(r.uleb(), 2)
+		for i := uint64(0); i < narray && r.err == nil; i++ {
+			t.Array = append(t.Array, r.ktabk())
+		}
+		for i := uint64(0); i < nhash && r.err == nil; i++ {
+			t.Hash = append(t.Hash, [2]KTabK{r.ktabk(), r.ktabk()})
+		}
+		k.Tab = t
+	case k.Type == kgcI64, k.Type == kgcU64:
+		lo := r.uleb()
+		hi := r.uleb()
+		k.U64 = joinHalves(lo, hi)
+		k.I64 = int64(k.U64)
+	case k.Type == kgcComplex:
+		k.Real = r.num()
+		k.Imag = r.num()
+	case k.Type >= kgcStr:
+		k.Str = r.str(k.Type - kgcStr)
+		k.Type = kgcStr
+	}
+	return k
+}
+
+func (r *dumpReader) knum() KNum {
+	lo := r.uleb()
+	if lo&1 == 0 {
+		return KNum{IsInt: true, Int: int32(uint32(lo >> 1))}
+	}
+	hi := r.uleb()
+	return KNum{Num: math.Float64frombits(joinHalves(lo>>1, hi))}
+}
+
+// line info entry size in bytes
+func (p *Proto) lineWidth() int {
+	switch {
+	case p.NumLine < 256:
+		return 1
+	case p.NumLine < 65536:
+		return 2
+	default:
+		return 4
+	}
+}
+
+func (p *Proto) parseDebug(be bool) {
+	r := &dumpReader{buf: p.Debug, be: be}
+
+	for i := 0; i < len(p.Ins) && r.err == nil; i++ {
+		switch p.lineWidth() {
+		case 1:
+			p.LineInfo = append(p.LineInfo, uint64(r.u8()))
+		case 2:
+			p.LineInfo = append(p.LineInfo, uint64(r.u16()))
+		default:
+			p.LineInfo = append(p.LineInfo, uint64(r.u32()))
+		}
+	}
+
+	readStr := func(first byte) string {
+		s := []byte{first}
+		for r.err == nil {
+			b := r.u8()
+			if b == 0 {
+				break
+			}
+			s = append(s, b)
+		}
+		return string(s)
+	}
+
+	for i := 0; i < len(p.UV) && r.err == nil; i++ {
+		b := r.u8()
+		if b == 0 {
+			p.UVNames = append(p.UVNames, "")
+			continue
+		}
+		p.UVNames = append(p.UVNames, readStr(b))
+	}
+
+	var lastPC uint64
+	for r.err == nil {
+		b := r.u8()
+		if b == 0 || r.err != nil {
+			break
+		}
+		v := VarInfo{}
+		if b < uint8(len(varInfoInternalNames)) {
+			v.Type = b
+		} else {
+			v.Name = readStr(b)
+		}
+		v.StartPC = lastPC + r.uleb()
+		v.EndPC = v.StartPC + r.uleb()
+		lastPC = v.StartPC
+		if r.err == nil {
+			p.VarInfo = append(p.VarInfo, v)
+		}
+	}
+}
+
+func (r *dumpReader) proto(dump *Dump, index int) *Proto {
+	overlong := r.overlong
+	p := &Proto{Index: index, Offset: r.pos}
+	length := r.uleb()
+	end := r.pos + int(r.count(length, 1))
+
+	p.Flags = r.u8()
+	p.NumParams = r.u8()
+	p.FrameSize = r.u8()
+	numuv := r.u8()
+	numkgc := r.count(r.uleb(), 1)
+	numkn := r.count(r.uleb(), 1)
+	numbc := r.count(r.uleb(), 4)
+
+	var debuglen uint64
+	if !dump.Strip() {
+		debuglen = r.uleb()
+		if debuglen > 0 {
+			p.FirstLine = r.uleb()
+			p.NumLine = r.uleb()
+		}
+	}
+
+	p.InsOffset = r.pos
+	for i := uint64(0); i < numbc && r.err == nil; i++ {
+		p.Ins = append(p.Ins, insFromWord(r.u32()))
+	}
+	for i := 0; i < int(numuv) && r.err == nil; i++ {
+		p.UV = append(p.UV, r.u16())
+	}
+	for i := uint64(0); i < numkgc && r.err == nil; i++ {
+		p.KGC = append(p.KGC, r.kgc())
+	}
+	for i := uint64(0); i < numkn && r.err == nil; i++ {
+		p.KNum = append(p.KNum, r.knum())
+	}
+	p.Debug = r.bytes(r.count(debuglen, 1))
+
+	if r.err == nil && r.pos != end {
+		r.err = fmt.Errorf("proto %d length %d does not match content", index, length)
+	}
+	if r.err == nil && len(p.Debug) > 0 {
+		p.parseDebug(r.be)
+	}
+	p.OverlongULEB = r.overlong - overlong
+
+	return p
+}
+
+// ParseDump parses a complete bytecode dump, opcodes is used for the dump
+// instead of the one based on version if non-nil
+func ParseDump(buf []byte, opcodes BcDefList) (*Dump, error) {
+	r := &dumpReader{buf: buf}
+	dump, err := r.header(opcodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// children are popped from a stack of already read protos
+	var stack []*Proto
+	for r.err == nil {
+		if r.pos < len(r.buf) && r.buf[r.pos] == 0 {
+			r.pos++
+			break
+		}
+		p := r.proto(dump, len(dump.Protos))
+		if r.err != nil {
+			break
+		}
+		if err := dump.link(p, &stack); err != nil {
+			return nil, err
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return dump, nil
+}
+
+func (r *dumpReader) header(opcodes BcDefList) (*Dump, error) {
+	if string(r.bytes(3)) != "\x1bLJ" {
+		return nil, errors.New("not a LuaJIT bytecode dump")
+	}
+	dump := &Dump{}
+	dump.Version = uint64(r.u8())
+	dump.Flags = r.uleb()
+	if !dump.Strip() {
+		dump.Name = r.str(r.uleb())
+	}
+	r.be = dump.BigEndian()
+	dump.OverlongULEB = r.overlong
+
+	dump.Opcodes = opcodes
+	if dump.Opcodes == nil {
+		dump.Opcodes = versionOpcodes[dump.Version]
+	}
+	if dump.Opcodes == nil {
+		dump.Opcodes = opcodesLuaJIT21
+	}
+	return dump, r.err
+}
+
+// set child constants from the stack of read protos and add p
+func (dump *Dump) link(p *Proto, stack *[]*Proto) error {
+	if err := linkChildren(p, stack); err != nil {
+		return err
+	}
+	dump.Protos = append(dump.Protos, p)
+	return nil
+}
+
+// set child constants of p from the stack of read protos and push p
+func linkChildren(p *Proto, stack *[]*Proto) error {
+	for i := range p.KGC {
+		if p.KGC[i].Type != kgcChild {
+			continue
+		}
+		if len(*stack) == 0 {
+			return fmt.Errorf("proto %d: child constant without proto", p.Index)
+		}
+		c := (*stack)[len(*stack)-1]
+		*stack = (*stack)[:len(*stack)-1]
+		c.Parent = p
+		p.KGC[i].Child = c
+	}
+	*stack = append(*stack, p)
+	return nil
+}
+
+// parse dump from the bytes of a value, ex a decoded luajit root or a binary
+func toDump(v any) (*Dump, error) {
+	br, err := interp.ToBitReader(v)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := io.ReadAll(bitio.NewIOReader(br))
+	if err != nil {
+		return nil, err
+	}
+	// shebang or other bytes before the signature
+	offset := 0
+	if i := bytes.Index(buf, []byte("\x1bLJ")); i > 0 {
+		buf = buf[i:]
+		offset = i
+	}
+	dump, err := ParseDump(buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	dump.Offset = offset
+	return dump, nil
+}
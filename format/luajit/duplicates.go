@@ -0,0 +1,111 @@
+package luajit
+
+// string constants repeated across protos, each proto has its own copy
+
+import (
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_duplicate_strings", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return DuplicateStrings(dump)
+	})
+}
+
+// DuplicateStrings lists strings that are in more than one string constant,
+// also keys and values in table constants. Count is number of copies,
+// references is number of instructions using the string constants and savings
+// is bytes used by all but one copy. Sorted by savings
+func DuplicateStrings(dump *Dump) []any {
+	type dup struct {
+		count      int
+		references int
+		protos     []any
+	}
+	byStr := map[string]*dup{}
+	add := func(p *Proto, s string) *dup {
+		d, ok := byStr[s]
+		if !ok {
+			d = &dup{protos: []any{}}
+			byStr[s] = d
+		}
+		d.count++
+		if len(d.protos) == 0 || d.protos[len(d.protos)-1] != p.Index {
+			d.protos = append(d.protos, p.Index)
+		}
+		return d
+	}
+
+	for _, p := range dump.Protos {
+		refs := map[int]int{}
+		for _, ins := range p.Ins {
+			def := dump.Opcodes.Get(int(ins.Op))
+			if def.MC != BcMstr {
+				continue
+			}
+			operand := int(ins.D)
+			if !def.HasD() {
+				operand = int(ins.C)
+			}
+			refs[operand]++
+		}
+
+		ktabk := func(k KTabK) {
+			if k.Type == ktabStr {
+				add(p, k.Str)
+			}
+		}
+		for i, k := range p.KGC {
+			switch k.Type {
+			case kgcStr:
+				add(p, k.Str).references += refs[len(p.KGC)-1-i]
+			case kgcTab:
+				for _, v := range k.Tab.Array {
+					ktabk(v)
+				}
+				for _, kv := range k.Tab.Hash {
+					ktabk(kv[0])
+					ktabk(kv[1])
+				}
+			}
+		}
+	}
+
+	var strs []string
+	for s, d := range byStr {
+		if d.count > 1 {
+			strs = append(strs, s)
+		}
+	}
+	// type and length as ULEB128 of 5 + length, same for kgc and table strings
+	savings := func(s string) int {
+		return (byStr[s].count - 1) * (ulebSize(uint64(kgcStr+len(s))) + len(s))
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		si, sj := savings(strs[i]), savings(strs[j])
+		if si != sj {
+			return si > sj
+		}
+		return strs[i] < strs[j]
+	})
+
+	dups := []any{}
+	for _, s := range strs {
+		d := byStr[s]
+		dups = append(dups, map[string]any{
+			"value":      s,
+			"length":     len(s),
+			"count":      d.count,
+			"references": d.references,
+			"protos":     d.protos,
+			"savings":    savings(s),
+		})
+	}
+	return dups
+}
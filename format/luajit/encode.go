@@ -0,0 +1,223 @@
+package luajit
+
+// encode a dump model back to bytes, lengths and counts are recomputed and
+// ULEB128 are written with as few bytes as possible like LuaJIT does
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
+)
+
+type dumpWriter struct {
+	buf []byte
+	be  bool
+	// start of fields as paths like in the decode tree, if marking
+	marking bool
+	marks   []writeMark
+}
+
+type writeMark struct {
+	pos   int
+	field string
+}
+
+func (w *dumpWriter) mark(format string, a ...any) {
+	if w.marking {
+		w.marks = append(w.marks, writeMark{pos: len(w.buf), field: fmt.Sprintf(format, a...)})
+	}
+}
+
+func (w *dumpWriter) bytes(bs []byte) { w.buf = append(w.buf, bs...) }
+func (w *dumpWriter) u8(v uint8)      { w.buf = append(w.buf, v) }
+
+func (w *dumpWriter) u16(v uint16) {
+	var bs [2]byte
+	if w.be {
+		binary.BigEndian.PutUint16(bs[:], v)
+	} else {
+		binary.LittleEndian.PutUint16(bs[:], v)
+	}
+	w.bytes(bs[:])
+}
+
+func (w *dumpWriter) u32(v uint32) {
+	var bs [4]byte
+	if w.be {
+		binary.BigEndian.PutUint32(bs[:], v)
+	} else {
+		binary.LittleEndian.PutUint32(bs[:], v)
+	}
+	w.bytes(bs[:])
+}
+
+func (w *dumpWriter) uleb(v uint64) {
+	for v >= 0x80 {
+		w.buf = append(w.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *dumpWriter) num(f float64) {
+	u := math.Float64bits(f)
+	w.uleb(u & 0xffffffff)
+	w.uleb(u >> 32)
+}
+
+func (w *dumpWriter) ktabk(k KTabK) {
+	switch k.Type {
+	case ktabInt:
+		w.uleb(ktabInt)
+		w.uleb(uint64(uint32(k.Int)))
+	case ktabNum:
+		w.uleb(ktabNum)
+		w.num(k.Num)
+	case ktabStr:
+		w.uleb(ktabStr + uint64(len(k.Str)))
+		w.bytes([]byte(k.Str))
+	default:
+		w.uleb(k.Type)
+	}
+}
+
+func (w *dumpWriter) kgc(k KGC) {
+	switch k.Type {
+	case kgcChild:
+		w.uleb(kgcChild)
+	case kgcTab:
+		w.uleb(kgcTab)
+		w.uleb(uint64(len(k.Tab.Array)))
+		w.uleb(uint64(len(k.Tab.Hash)))
+		for _, v := range k.Tab.Array {
+			w.ktabk(v)
+		}
+		for _, kv := range k.Tab.Hash {
+			w.ktabk(kv[0])
+			w.ktabk(kv[1])
+		}
+	case kgcI64, kgcU64:
+		w.uleb(k.Type)
+		w.uleb(k.U64 & 0xffffffff)
+		w.uleb(k.U64 >> 32)
+	case kgcComplex:
+		w.uleb(kgcComplex)
+		w.num(k.Real)
+		w.num(k.Imag)
+	default:
+		w.uleb(kgcStr + uint64(len(k.Str)))
+		w.bytes([]byte(k.Str))
+	}
+}
+
+func (w *dumpWriter) knum(k KNum) {
+	if k.IsInt {
+		w.uleb(uint64(uint32(k.Int)) << 1)
+		return
+	}
+	u := math.Float64bits(k.Num)
+	w.uleb((u&0xffffffff)<<1 | 1)
+	w.uleb(u >> 32)
+}
+
+// instruction word, B and C or D depending on opcode
+func (dump *Dump) insWord(ins Ins) uint32 {
+	if dump.Opcodes.Get(int(ins.Op)).HasD() {
+		return uint32(ins.Op) | uint32(ins.A)<<8 | uint32(ins.D)<<16
+	}
+	return uint32(ins.Op) | uint32(ins.A)<<8 | uint32(ins.C)<<16 | uint32(ins.B)<<24
+}
+
+func (dump *Dump) encodeProto(p *Proto, marking bool) ([]byte, []writeMark) {
+	w := &dumpWriter{be: dump.BigEndian(), marking: marking}
+	pd := fmt.Sprintf(".proto[%d].pdata", p.Index)
+
+	w.mark("%s.phead.flags", pd)
+	w.u8(p.Flags)
+	w.mark("%s.phead.numparams", pd)
+	w.u8(p.NumParams)
+	w.mark("%s.phead.framesize", pd)
+	w.u8(p.FrameSize)
+	w.mark("%s.phead.numuv", pd)
+	w.u8(uint8(len(p.UV)))
+	w.mark("%s.phead.numkgc", pd)
+	w.uleb(uint64(len(p.KGC)))
+	w.mark("%s.phead.numkn", pd)
+	w.uleb(uint64(len(p.KNum)))
+	w.mark("%s.phead.numbc", pd)
+	w.uleb(uint64(len(p.Ins)))
+	if !dump.Strip() {
+		w.mark("%s.phead.debuglen", pd)
+		w.uleb(uint64(len(p.Debug)))
+		if len(p.Debug) > 0 {
+			w.mark("%s.phead.firstline", pd)
+			w.uleb(p.FirstLine)
+			w.mark("%s.phead.numline", pd)
+			w.uleb(p.NumLine)
+		}
+	}
+	for i, ins := range p.Ins {
+		w.mark("%s.bcins[%d]", pd, i)
+		w.u32(dump.insWord(ins))
+	}
+	for i, uv := range p.UV {
+		w.mark("%s.uvdata[%d]", pd, i)
+		w.u16(uv)
+	}
+	for i, k := range p.KGC {
+		w.mark("%s.kgc[%d]", pd, i)
+		w.kgc(k)
+	}
+	for i, k := range p.KNum {
+		w.mark("%s.knum[%d]", pd, i)
+		w.knum(k)
+	}
+	if !dump.Strip() {
+		w.mark("%s.debug", pd)
+		w.bytes(p.Debug)
+	}
+
+	return w.buf, w.marks
+}
+
+func (dump *Dump) encodeHeader(w *dumpWriter) {
+	w.mark(".header.magic")
+	w.bytes([]byte("\x1bLJ"))
+	w.mark(".header.version")
+	w.u8(uint8(dump.Version))
+	w.mark(".header.flags")
+	w.uleb(dump.Flags)
+	if !dump.Strip() {
+		w.mark(".header.namelen")
+		w.uleb(uint64(len(dump.Name)))
+		w.mark(".header.name")
+		w.bytes([]byte(dump.Name))
+	}
+}
+
+// Encode serializes the dump, debug info is written as is
+func (dump *Dump) Encode() []byte {
+	w := &dumpWriter{be: dump.BigEndian()}
+
+	dump.encodeHeader(w)
+	for _, p := range dump.Protos {
+		pbuf, _ := dump.encodeProto(p, false)
+		w.uleb(uint64(len(pbuf)))
+		w.bytes(pbuf)
+	}
+	w.u8(0)
+
+	return w.buf
+}
+
+// binary value or error
+func toBinary(buf []byte) any {
+	bb, err := interp.NewBinaryFromBitReader(bitio.NewBitReader(buf, -1), 8, 0)
+	if err != nil {
+		return err
+	}
+	return bb
+}
@@ -0,0 +1,94 @@
+package luajit
+
+// protos reachable from the main chunk by FNEW, LuaJIT only writes protos
+// that are child constants of the main chunk or its children
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_entry_points", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return EntryPoints(dump)
+	})
+}
+
+// EntryPoints is per proto in dump order the status: main, reachable by FNEW
+// in reachable code from the main chunk, unreachable with a reason, or
+// orphan if it is not a child constant of any proto. Path is proto indexes
+// from the main chunk for reachable protos
+func EntryPoints(dump *Dump) []any {
+	main := dump.Main()
+	paths := map[*Proto][]any{}
+	// child created by FNEW in unreachable code
+	deadFNEW := map[*Proto]bool{}
+	if main != nil {
+		paths[main] = []any{main.Index}
+		queue := []*Proto{main}
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			blocks, reached := dump.reachedBlocks(p)
+			for _, b := range blocks {
+				for pc := b.Start; pc < b.End; pc++ {
+					if dump.OpName(p, pc) != "FNEW" {
+						continue
+					}
+					k := p.KGCByD(int(p.Ins[pc].D))
+					if k == nil || k.Child == nil {
+						continue
+					}
+					if !reached[b.Start] {
+						deadFNEW[k.Child] = true
+						continue
+					}
+					if _, ok := paths[k.Child]; ok {
+						continue
+					}
+					paths[k.Child] = append(append([]any{}, paths[p]...), k.Child.Index)
+					queue = append(queue, k.Child)
+				}
+			}
+		}
+	}
+
+	protos := []any{}
+	for _, p := range dump.Protos {
+		e := map[string]any{
+			"proto": p.Index,
+			"name":  dump.ProtoName(p),
+		}
+		path, reachable := paths[p]
+		switch {
+		case p == main:
+			e["status"] = "main"
+		case reachable:
+			e["status"] = "reachable"
+		case p.Parent == nil:
+			e["status"] = "orphan"
+			e["reason"] = "not a child constant"
+		default:
+			e["status"] = "unreachable"
+			switch {
+			case deadFNEW[p]:
+				e["reason"] = "FNEW in unreachable code"
+			case fnewPC(dump, p) < 0:
+				e["reason"] = "no FNEW in parent"
+			default:
+				e["reason"] = "parent unreachable"
+			}
+		}
+		if p.Parent != nil {
+			e["parent"] = p.Parent.Index
+		}
+		if reachable {
+			e["path"] = path
+		}
+		protos = append(protos, e)
+	}
+	return protos
+}
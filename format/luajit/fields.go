@@ -0,0 +1,108 @@
+package luajit
+
+// table fields read and written by TGETS and TSETS
+
+import (
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_fields", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Fields(dump)
+	})
+}
+
+// Fields is per field the instructions reading and writing it, sorted by name.
+// Base is the global or field path of the table if the slot was last assigned
+// by GGET, TGETS or MOV of one, linear so ignores control flow. Name is
+// base.field, or field if base is unknown
+func Fields(dump *Dump) []any {
+	type access struct {
+		base   any
+		field  string
+		reads  []any
+		writes []any
+	}
+	byName := map[string]*access{}
+	for _, p := range dump.Protos {
+		// slot to global or field path
+		slots := map[int]string{}
+		for pc, ins := range p.Ins {
+			name := dump.OpName(p, pc)
+			a := int(ins.A)
+			var path string
+			switch name {
+			case "GGET":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr {
+					path = k.Str
+				}
+			case "MOV":
+				path = slots[int(ins.D)]
+			case "TGETS", "TSETS":
+				k := p.KGCByD(int(ins.C))
+				if k == nil || k.Type != kgcStr {
+					break
+				}
+				base, known := slots[int(ins.B)]
+				n := k.Str
+				if known {
+					n = base + "." + k.Str
+				}
+				acc, ok := byName[n]
+				if !ok {
+					acc = &access{field: k.Str, reads: []any{}, writes: []any{}}
+					if known {
+						acc.base = base
+					}
+					byName[n] = acc
+				}
+				loc := map[string]any{
+					"proto":      p.Index,
+					"proto_name": dump.ProtoName(p),
+					"pc":         pc,
+				}
+				if len(p.LineInfo) == len(p.Ins) {
+					loc["line"] = int(p.Line(pc))
+				}
+				if name == "TGETS" {
+					acc.reads = append(acc.reads, loc)
+					if known {
+						path = n
+					}
+				} else {
+					acc.writes = append(acc.writes, loc)
+				}
+			}
+			if def := dump.Opcodes.Get(int(ins.Op)); def.MA == BcMdst || def.MA == BcMbase {
+				delete(slots, a)
+			}
+			if path != "" {
+				slots[a] = path
+			}
+		}
+	}
+
+	var names []string
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fields := []any{}
+	for _, n := range names {
+		acc := byName[n]
+		fields = append(fields, map[string]any{
+			"name":   n,
+			"base":   acc.base,
+			"field":  acc.field,
+			"reads":  acc.reads,
+			"writes": acc.writes,
+		})
+	}
+	return fields
+}
@@ -0,0 +1,125 @@
+package luajit
+
+// hash of what a proto does, same for stripped and unstripped dumps and dumps
+// of the same source with another chunk name
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_fingerprint", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return FingerprintReport(dump)
+	})
+}
+
+// Fingerprints are per proto hashes of parameters, upvalues, instructions by
+// opcode name (so 2.0 and 2.1 dumps can match) and constants, child
+// constants are included as the child fingerprint. Debug info, chunk name and
+// byte order are ignored
+func Fingerprints(dump *Dump) map[*Proto]string {
+	fps := map[*Proto]string{}
+	// children are before their parent in dump order
+	for _, p := range dump.Protos {
+		h := sha256.New()
+		var buf [8]byte
+		u := func(v uint64) {
+			binary.LittleEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		s := func(v string) {
+			u(uint64(len(v)))
+			h.Write([]byte(v))
+		}
+
+		u(uint64(p.NumParams))
+		u(uint64(p.Flags & protoFlagVararg))
+		u(uint64(len(p.UV)))
+		for _, uv := range p.UV {
+			u(uint64(uv))
+		}
+
+		u(uint64(len(p.Ins)))
+		for _, ins := range p.Ins {
+			s(dump.Opcodes.Get(int(ins.Op)).Name)
+			u(uint64(ins.A))
+			u(uint64(ins.D))
+		}
+
+		u(uint64(len(p.KGC)))
+		for _, k := range p.KGC {
+			u(k.Type)
+			switch k.Type {
+			case kgcChild:
+				if k.Child != nil {
+					s(fps[k.Child])
+				}
+			case kgcTab:
+				tk := func(k KTabK) string {
+					return fmt.Sprintf("%d:%v", k.Type, k.value())
+				}
+				u(uint64(len(k.Tab.Array)))
+				for _, v := range k.Tab.Array {
+					s(tk(v))
+				}
+				// hash part is in node order which depends on how the table was built
+				var pairs []string
+				for _, kv := range k.Tab.Hash {
+					pairs = append(pairs, tk(kv[0])+"="+tk(kv[1]))
+				}
+				sort.Strings(pairs)
+				for _, kv := range pairs {
+					s(kv)
+				}
+			case kgcI64:
+				u(uint64(k.I64))
+			case kgcU64:
+				u(k.U64)
+			case kgcComplex:
+				u(math.Float64bits(k.Real))
+				u(math.Float64bits(k.Imag))
+			default:
+				s(k.Str)
+			}
+		}
+
+		u(uint64(len(p.KNum)))
+		for _, k := range p.KNum {
+			if k.IsInt {
+				u(0)
+				u(uint64(k.Int))
+			} else {
+				u(1)
+				u(math.Float64bits(k.Num))
+			}
+		}
+
+		fps[p] = hex.EncodeToString(h.Sum(nil)[0:8])
+	}
+	return fps
+}
+
+// FingerprintReport lists proto fingerprints in dump order
+func FingerprintReport(dump *Dump) []any {
+	fps := Fingerprints(dump)
+	protos := []any{}
+	for _, p := range dump.Protos {
+		protos = append(protos, map[string]any{
+			"proto":       p.Index,
+			"name":        dump.ProtoName(p),
+			"fingerprint": fps[p],
+		})
+	}
+	return protos
+}
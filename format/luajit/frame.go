@@ -0,0 +1,125 @@
+package luajit
+
+// highest slot referenced by instructions compared to declared framesize
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_framesize", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return FrameSizeReport(dump)
+	})
+}
+
+// highest slot instruction pc references, -1 if none. Ranges are as in
+// lj_bc.h and with fr2 call arguments start at A+2. Operands that are
+// only the first free slot (JMP, LOOP and UCLO) are not references
+func (dump *Dump) maxSlot(p *Proto, pc int) int {
+	ins := p.Ins[pc]
+	def := dump.Opcodes.Get(int(ins.Op))
+	a, b, c, d := int(ins.A), int(ins.B), int(ins.C), int(ins.D)
+	fr2 := 0
+	if dump.FR2() {
+		fr2 = 1
+	}
+	max := func(vs ...int) int {
+		m := -1
+		for _, v := range vs {
+			if v > m {
+				m = v
+			}
+		}
+		return m
+	}
+
+	switch def.Name {
+	case "KNIL":
+		return d
+	case "CAT":
+		return max(a, c)
+	case "CALL":
+		return max(a+fr2, a+c-1+fr2, a+b-2)
+	case "CALLM":
+		return max(a+fr2, a+c+fr2, a+b-2)
+	case "CALLT":
+		return max(a+fr2, a+d-1+fr2)
+	case "CALLMT":
+		return a + d + fr2
+	case "ITERC", "ITERN":
+		return max(a+2+fr2, a+b-2)
+	case "VARG":
+		return max(a, a+b-2)
+	case "RET":
+		return max(a, a+d-2)
+	case "RETM":
+		return a + d
+	case "RET1":
+		return a
+	case "RET0", "JMP", "LOOP", "ILOOP", "JLOOP", "UCLO":
+		return -1
+	case "FORI", "JFORI", "FORL", "IFORL", "JFORL":
+		return a + 3
+	}
+
+	slot := -1
+	operands := [][2]int{{def.MA, a}, {def.MB, b}, {def.MC, c}}
+	if def.HasD() {
+		operands = [][2]int{{def.MA, a}, {def.MC, d}}
+	}
+	for _, o := range operands {
+		switch o[0] {
+		case BcMdst, BcMbase, BcMvar, BcMrbase:
+			slot = max(slot, o[1])
+		}
+	}
+	return slot
+}
+
+// FrameSizeReport is per proto the number of slots used by parameters and
+// instructions compared to framesize, status is "over" if more slots are
+// declared than used and "under" if instructions use slots outside the frame
+// which LuaJIT does not check when loading
+func FrameSizeReport(dump *Dump) []any {
+	var protos []any
+	for _, p := range dump.Protos {
+		// minimum frame size in lj_parse fs_init
+		used := 1
+		if int(p.NumParams) > used {
+			used = int(p.NumParams)
+		}
+		maxPC := -1
+		for pc := range p.Ins {
+			if s := dump.maxSlot(p, pc); s+1 > used {
+				used = s + 1
+				maxPC = pc
+			}
+		}
+
+		status := "ok"
+		switch fs := int(p.FrameSize); {
+		case used > fs:
+			status = "under"
+		case used < fs:
+			status = "over"
+		}
+
+		r := map[string]any{
+			"proto":     p.Index,
+			"name":      dump.ProtoName(p),
+			"framesize": int(p.FrameSize),
+			"used":      used,
+			"slack":     int(p.FrameSize) - used,
+			"status":    status,
+		}
+		if maxPC >= 0 {
+			r["max_pc"] = maxPC
+		}
+		protos = append(protos, r)
+	}
+	return protos
+}
@@ -0,0 +1,246 @@
+package luajit
+
+// GCproto as laid out in process memory, see lj_obj.h and lj_bcread.c
+//
+// gcproto = header bcW* kgcP* knumT* uvH* [debug]
+// header  = nextgcP markedB gctB numparamsB framesizeB sizebcW [unusedW pad]
+//           gclistP kP uvP sizekgcW sizeknW sizeptW sizeuvB flagsB traceH
+//           chunknameP firstlineW numlineW lineinfoP uvinfoP varinfoP
+//
+// P = GCRef/MRef, 4 bytes or 8 with GC64, T = TValue 8 bytes. bc starts with
+// the function header added by lj_bcread. k points between kgc, which is
+// indexed backwards like in a dump, and knum. Constants other than numbers
+// are GCRefs to objects somewhere else on the heap
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.LuaJIT_GCProto,
+		&decode.Format{
+			Description: "LuaJIT GCproto in process memory",
+			DecodeFn:    LuaJITGCProtoDecode,
+			DefaultInArg: format.LuaJIT_GCProto_In{
+				Base:        0,
+				Address:     0,
+				PointerSize: 8,
+				Version:     2.1,
+				BigEndian:   false,
+			},
+		})
+}
+
+// gct is the negated internal type tag, ~LJ_TSTR etc
+const (
+	gctStr   = 4
+	gctProto = 7
+)
+
+var gctMap = scalar.UintMapSymStr{
+	4:  "str",
+	5:  "upval",
+	6:  "thread",
+	7:  "proto",
+	8:  "func",
+	9:  "trace",
+	10: "cdata",
+	11: "tab",
+	12: "udata",
+}
+
+// GCstr len and data offsets, without and with the sid field added in 2.1
+// 2020, data is NUL terminated
+var gcStrLayouts = map[uint64][][2]int64{
+	4: {{12, 16}, {16, 20}},
+	8: {{16, 24}, {20, 24}},
+}
+
+type gcMemory struct {
+	d       *decode.D
+	base    uint64
+	ptrSize uint64
+}
+
+// input byte offset of an address, ok if in the input
+func (m gcMemory) offset(addr uint64) (int64, bool) {
+	if addr < m.base || addr-m.base >= uint64(m.d.Len()/8) {
+		return 0, false
+	}
+	return int64(addr - m.base), true
+}
+
+func (m gcMemory) uint(off int64, size int64) uint64 {
+	v := uint64(0)
+	for i, b := range m.d.BytesRange(off*8, int(size)) {
+		if m.d.Endian == decode.BigEndian {
+			v = v<<8 | uint64(b)
+		} else {
+			v |= uint64(b) << (8 * i)
+		}
+	}
+	return v
+}
+
+// string of a GCstr at off, ok if one of the layouts has a NUL at data+len
+func (m gcMemory) str(off int64) (string, bool) {
+	end := m.d.Len() / 8
+	for _, l := range gcStrLayouts[m.ptrSize] {
+		if off+l[0]+4 > end {
+			continue
+		}
+		n := int64(m.uint(off+l[0], 4))
+		if off+l[1]+n >= end || m.d.BytesRange((off+l[1]+n)*8, 1)[0] != 0 {
+			continue
+		}
+		return string(m.d.BytesRange((off+l[1])*8, int(n))), true
+	}
+	return "", false
+}
+
+// decode GCRef to a heap object, type and string value if it is in the input
+func (m gcMemory) fieldRef(d *decode.D, name string) {
+	ref := d.FieldU(name, int(m.ptrSize)*8, scalar.UintHex)
+	off, ok := m.offset(ref)
+	if !ok || off+int64(m.ptrSize)+2 > d.Len()/8 {
+		return
+	}
+	d.FieldValueUint("offset", uint64(off))
+	gct := m.uint(off+int64(m.ptrSize)+1, 1)
+	d.FieldValueUint("gct", gct, gctMap)
+	if gct == gctStr {
+		if s, ok := m.str(off); ok {
+			d.FieldValueStr("value", s)
+		}
+	}
+}
+
+func LuaJITGCProtoDecode(d *decode.D) any {
+	var opts format.LuaJIT_GCProto_In
+	d.ArgAs(&opts)
+	if opts.PointerSize != 4 && opts.PointerSize != 8 {
+		d.Fatalf("unknown pointer_size %d", opts.PointerSize)
+	}
+	version, ok := versionOptions[opts.Version]
+	if !ok {
+		d.Fatalf("unknown version %v", opts.Version)
+	}
+
+	di := &DumpInfo{
+		Version:   version,
+		BigEndian: opts.BigEndian,
+		FR2:       opts.PointerSize == 8,
+		InMemory:  true,
+		Opts:      format.LuaJIT_In{WideInt: "decimal", FloatFormat: "decimal", NumberModel: "auto"},
+		Pos:       ProtoPos{Ins: -1, KGC: -1, KNum: -1},
+	}
+	di.SetOpcodes()
+	if opts.BigEndian {
+		d.Endian = decode.BigEndian
+	} else {
+		d.Endian = decode.LittleEndian
+	}
+
+	m := gcMemory{d: d, base: opts.Base, ptrSize: opts.PointerSize}
+	addr := opts.Address
+	if addr == 0 {
+		addr = opts.Base
+	}
+	start, ok := m.offset(addr)
+	if !ok {
+		d.Fatalf("address %#x not in input", addr)
+	}
+	d.SeekAbs(start * 8)
+
+	ptrBits := int(opts.PointerSize) * 8
+	var sizebc, k, uv, sizekgc, sizekn, sizeuv uint64
+	d.FieldStruct("header", func(d *decode.D) {
+		d.FieldU("nextgc", ptrBits, scalar.UintHex)
+		d.FieldU8("marked", scalar.UintHex)
+		d.FieldU8("gct", gctMap, d.UintAssert(gctProto))
+		d.FieldU8("numparams")
+		d.FieldU8("framesize")
+		sizebc = d.FieldU32("sizebc", scalar.UintDescription("includes function header"))
+		if opts.PointerSize == 8 {
+			d.FieldU32("unused_gc")
+			d.FieldRawLen("padding", 32)
+		}
+		d.FieldU("gclist", ptrBits, scalar.UintHex)
+		k = d.FieldU("k", ptrBits, scalar.UintHex)
+		uv = d.FieldU("uv", ptrBits, scalar.UintHex)
+		sizekgc = d.FieldU32("sizekgc")
+		sizekn = d.FieldU32("sizekn")
+		d.FieldU32("sizept", scalar.UintDescription("including colocated arrays"))
+		sizeuv = d.FieldU8("sizeuv")
+		di.ProtoFlags = d.FieldU8("flags", scalar.UintHex)
+		d.FieldU16("trace")
+		d.FieldStruct("chunkname", func(d *decode.D) { m.fieldRef(d, "ref") })
+		d.FieldU32("firstline")
+		d.FieldU32("numline")
+		d.FieldU("lineinfo", ptrBits, scalar.UintHex)
+		d.FieldU("uvinfo", ptrBits, scalar.UintHex)
+		d.FieldU("varinfo", ptrBits, scalar.UintHex)
+	})
+
+	LuaJITCheckCount(di, d, "sizebc", sizebc, 4)
+	d.FieldArray("bc", func(d *decode.D) {
+		for i := uint64(0); i < sizebc; i++ {
+			di.Pos.Ins = int(i)
+			d.FieldStruct("ins", func(d *decode.D) { LuaJITDecodeBCIns(di, d) })
+		}
+		di.Pos.Ins = -1
+	})
+
+	// colocated arrays follow bc, k and uv can point anywhere if damaged so
+	// only decode forward from here
+	kOff, kOK := m.offset(k)
+	kgcOff := kOff - int64(sizekgc*opts.PointerSize)
+	if !kOK || kgcOff*8 < d.Pos() {
+		LuaJITWarn(di, d, "k %#x not after bc, skipping constants", k)
+	} else {
+		d.SeekAbs(kgcOff * 8)
+		LuaJITCheckCount(di, d, "sizekgc", sizekgc, opts.PointerSize)
+		LuaJITCheckCount(di, d, "sizekn", sizekn, 8)
+		d.FieldArray("kgc", func(d *decode.D) {
+			for i := uint64(0); i < sizekgc; i++ {
+				d.FieldStruct("kgc", func(d *decode.D) {
+					d.FieldValueUint("index", i)
+					d.FieldValueUint("runtime_index", sizekgc-1-i)
+					m.fieldRef(d, "ref")
+				})
+			}
+		})
+		var knumBits []any
+		d.FieldArray("knum", func(d *decode.D) {
+			for i := uint64(0); i < sizekn; i++ {
+				d.FieldAnyScalarFn("knum", func(d *decode.D) scalar.Any {
+					u := d.U64()
+					knumBits = append(knumBits, numBits(u))
+					return di.numScalar(u)
+				})
+			}
+		})
+		d.FieldArray("knum_bits", func(d *decode.D) {
+			for _, b := range knumBits {
+				d.FieldValueAny("knum_bits", b)
+			}
+		})
+	}
+
+	if uvOff, ok := m.offset(uv); sizeuv > 0 && (!ok || uvOff*8 < d.Pos()) {
+		LuaJITWarn(di, d, "uv %#x not after constants, skipping upvalues", uv)
+	} else if sizeuv > 0 {
+		d.SeekAbs(uvOff * 8)
+		d.FieldArray("uv", func(d *decode.D) {
+			for i := uint64(0); i < sizeuv; i++ {
+				d.FieldU16("uv", uvDescription)
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+// generates opcode tables from the BCDEF macro in lj_bc.h
+//
+// usage: main.go VAR=lj_bc.h ...
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// _(ISLT,	var,	___,	var,	lt) \
+var bcdefRe = regexp.MustCompile(`^_\((\w+),\s*(\w+),\s*(\w+),\s*(\w+),\s*(\w+)\)`)
+
+// /* Unary ops. */ \
+var commentRe = regexp.MustCompile(`^/\*\s*(.*?)\s*\*/`)
+
+func mode(m string) string {
+	if m == "___" {
+		return "BcMnone"
+	}
+	return "BcM" + m
+}
+
+func gen(varName string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("// from %s\n", path)
+	fmt.Printf("var %s = BcDefList{\n", varName)
+
+	inBCDEF := false
+	n := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if !inBCDEF {
+			inBCDEF = strings.HasPrefix(l, "#define BCDEF(_)")
+			continue
+		}
+		last := !strings.HasSuffix(l, "\\")
+		l = strings.TrimSpace(strings.TrimSuffix(l, "\\"))
+
+		switch {
+		case l == "":
+			if n > 0 {
+				fmt.Println()
+			}
+		case commentRe.MatchString(l):
+			fmt.Printf("\t// %s\n", commentRe.FindStringSubmatch(l)[1])
+		case bcdefRe.MatchString(l):
+			m := bcdefRe.FindStringSubmatch(l)
+			fmt.Printf("\t{%q, %s, %s, %s},\n", m[1], mode(m[2]), mode(m[3]), mode(m[4]))
+			n++
+		default:
+			return fmt.Errorf("%s: unknown BCDEF line %q", path, l)
+		}
+		if last {
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: no BCDEF found", path)
+	}
+
+	fmt.Printf("}\n")
+
+	return nil
+}
+
+func main() {
+	fmt.Printf("// Code generated by gen/main.go from lj_bc.h, DO NOT EDIT.\n")
+	fmt.Printf("\n")
+	fmt.Printf("package luajit\n")
+
+	for _, arg := range os.Args[1:] {
+		varName, path, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "usage: %s VAR=lj_bc.h ...\n", os.Args[0])
+			os.Exit(1)
+		}
+		fmt.Printf("\n")
+		if err := gen(varName, path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package luajit
+
+// global names read and written by GGET and GSET
+
+import (
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_globals", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Globals(dump)
+	})
+}
+
+// Globals is per global name the instructions reading and writing it, sorted
+// by name
+func Globals(dump *Dump) []any {
+	type access struct {
+		reads  []any
+		writes []any
+	}
+	byName := map[string]*access{}
+	for _, p := range dump.Protos {
+		for pc, ins := range p.Ins {
+			name := dump.OpName(p, pc)
+			if name != "GGET" && name != "GSET" {
+				continue
+			}
+			k := p.KGCByD(int(ins.D))
+			if k == nil || k.Type != kgcStr {
+				continue
+			}
+			a, ok := byName[k.Str]
+			if !ok {
+				a = &access{reads: []any{}, writes: []any{}}
+				byName[k.Str] = a
+			}
+			loc := map[string]any{
+				"proto":      p.Index,
+				"proto_name": dump.ProtoName(p),
+				"pc":         pc,
+			}
+			if len(p.LineInfo) == len(p.Ins) {
+				loc["line"] = int(p.Line(pc))
+			}
+			if name == "GGET" {
+				a.reads = append(a.reads, loc)
+			} else {
+				a.writes = append(a.writes, loc)
+			}
+		}
+	}
+
+	var names []string
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	globals := []any{}
+	for _, n := range names {
+		globals = append(globals, map[string]any{
+			"name":   n,
+			"reads":  byName[n].reads,
+			"writes": byName[n].writes,
+		})
+	}
+	return globals
+}
@@ -0,0 +1,487 @@
+package luajit
+
+// control flow and call graphs of protos, with graphviz dot output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc1("_luajit_cfg_dot", func(_ *interp.Interp, c any, index int) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		if index < -1 || index >= len(dump.Protos) {
+			return fmt.Errorf("proto %d out of range", index)
+		}
+		return CFGDot(dump, index)
+	})
+	interp.RegisterFunc0("luajit_callgraph_dot", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return CallGraphDot(dump)
+	})
+	interp.RegisterFunc0("luajit_callgraph", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return CallGraph(dump)
+	})
+	interp.RegisterFunc0("luajit_unreachable", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Unreachable(dump)
+	})
+}
+
+func (dump *Dump) OpName(p *Proto, pc int) string {
+	if pc < 0 || pc >= len(p.Ins) {
+		return ""
+	}
+	return dump.Opcodes.Get(int(p.Ins[pc].Op)).Name
+}
+
+// instruction as text, ex "0005 FORI     2 => 0011"
+func (dump *Dump) InsString(p *Proto, pc int) string {
+	ins := p.Ins[pc]
+	def := dump.Opcodes.Get(int(ins.Op))
+	name := def.Name
+	if name == "" {
+		name = fmt.Sprintf("op%d", ins.Op)
+	}
+	switch {
+	case def.IsJump():
+		return fmt.Sprintf("%04d %-8s %3d => %04d", pc, name, ins.A, ins.Target(pc))
+	case def.IsLits():
+		return fmt.Sprintf("%04d %-8s %3d %5d", pc, name, ins.A, int16(ins.D))
+	case def.HasD():
+		return fmt.Sprintf("%04d %-8s %3d %5d", pc, name, ins.A, ins.D)
+	default:
+		return fmt.Sprintf("%04d %-8s %3d %3d %3d", pc, name, ins.A, ins.B, ins.C)
+	}
+}
+
+// test instructions are followed by a jump that is taken if the test is true
+func (dump *Dump) isTest(p *Proto, pc int) bool {
+	switch dump.OpName(p, pc) {
+	case "ISLT", "ISGE", "ISLE", "ISGT",
+		"ISEQV", "ISNEV", "ISEQS", "ISNES", "ISEQN", "ISNEN", "ISEQP", "ISNEP",
+		"ISTC", "ISFC", "IST", "ISF":
+		return true
+	}
+	return false
+}
+
+func isReturn(name string) bool {
+	switch name {
+	case "RET", "RET0", "RET1", "RETM", "CALLT", "CALLMT":
+		return true
+	}
+	return false
+}
+
+// Succs returns instruction indexes execution can continue at after pc,
+// jumps are returned before fall through
+func (dump *Dump) Succs(p *Proto, pc int) []int {
+	ins := p.Ins[pc]
+	name := dump.OpName(p, pc)
+	next := []int{}
+	if pc+1 < len(p.Ins) {
+		next = append(next, pc+1)
+	}
+
+	switch {
+	case isReturn(name):
+		return nil
+	case name == "LOOP", name == "ILOOP", name == "JLOOP":
+		// jump is only used by the JIT to exit the loop
+		return next
+	case name == "JMP" && pc > 0 && dump.isTest(p, pc-1):
+		return append([]int{ins.Target(pc)}, next...)
+	case name == "JMP", name == "UCLO", name == "ISNEXT":
+		return []int{ins.Target(pc)}
+	case dump.Opcodes.Get(int(ins.Op)).IsJump():
+		// for and iterator loops
+		return append([]int{ins.Target(pc)}, next...)
+	}
+	return next
+}
+
+type Block struct {
+	Start int
+	// exclusive
+	End   int
+	Succs []int
+}
+
+// Blocks splits instructions into basic blocks, successors are block start indexes
+func (dump *Dump) Blocks(p *Proto) []Block {
+	leaders := map[int]bool{0: true}
+	for pc := range p.Ins {
+		succs := dump.Succs(p, pc)
+		if len(succs) == 1 && succs[0] == pc+1 {
+			continue
+		}
+		// test and its jump stay in the same block
+		if dump.isTest(p, pc) && dump.OpName(p, pc+1) == "JMP" {
+			continue
+		}
+		for _, s := range succs {
+			leaders[s] = true
+		}
+		leaders[pc+1] = true
+	}
+
+	var starts []int
+	for pc := range leaders {
+		if pc >= 0 && pc < len(p.Ins) {
+			starts = append(starts, pc)
+		}
+	}
+	sort.Ints(starts)
+
+	var bs []Block
+	for i, start := range starts {
+		end := len(p.Ins)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		bs = append(bs, Block{Start: start, End: end, Succs: dump.Succs(p, end-1)})
+	}
+	return bs
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return s
+}
+
+// ProtoName is a short name for a proto, name of local or global it is assigned
+// to if known, otherwise by index
+func (dump *Dump) ProtoName(p *Proto) string {
+	if p == dump.Main() {
+		return "main"
+	}
+	if parent := p.Parent; parent != nil {
+		for pc, ins := range parent.Ins {
+			if dump.OpName(parent, pc) != "FNEW" || parent.KGCByD(int(ins.D)) == nil || parent.KGCByD(int(ins.D)).Child != p {
+				continue
+			}
+			if n := parent.VarName(pc+1, int(ins.A)); isLuaIdent(n) {
+				return n
+			}
+			// f = function() end
+			if next := pc + 1; dump.OpName(parent, next) == "GSET" && parent.Ins[next].A == ins.A {
+				if k := parent.KGCByD(int(parent.Ins[next].D)); k != nil && k.Type == kgcStr {
+					return k.Str
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("function_%d", p.Index)
+}
+
+// Unreachable lists basic blocks that can't be reached from the proto entry,
+// start and end (exclusive) are instruction indexes
+func Unreachable(dump *Dump) []any {
+	unreachable := []any{}
+	for _, p := range dump.Protos {
+		blocks, reached := dump.reachedBlocks(p)
+		for _, b := range blocks {
+			if reached[b.Start] {
+				continue
+			}
+			var ins []any
+			for pc := b.Start; pc < b.End; pc++ {
+				ins = append(ins, dump.InsString(p, pc))
+			}
+			unreachable = append(unreachable, map[string]any{
+				"proto":        p.Index,
+				"name":         dump.ProtoName(p),
+				"start":        b.Start,
+				"end":          b.End,
+				"instructions": ins,
+			})
+		}
+	}
+	return unreachable
+}
+
+// blocks of p and block starts reached from pc 0
+func (dump *Dump) reachedBlocks(p *Proto) ([]Block, map[int]bool) {
+	blocks := dump.Blocks(p)
+	byStart := map[int]Block{}
+	for _, b := range blocks {
+		byStart[b.Start] = b
+	}
+
+	reached := map[int]bool{}
+	stack := []int{0}
+	for len(stack) > 0 {
+		start := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		b, ok := byStart[start]
+		if !ok || reached[start] {
+			continue
+		}
+		reached[start] = true
+		stack = append(stack, b.Succs...)
+	}
+	return blocks, reached
+}
+
+// dot quoted label
+func (dump *Dump) protoLabel(p *Proto) string {
+	label := dotQuote(fmt.Sprintf("%s (proto %d)", dump.ProtoName(p), p.Index))
+	if p.NumLine > 0 || p.FirstLine > 0 {
+		label += fmt.Sprintf(`\nlines %d-%d`, p.FirstLine, p.FirstLine+p.NumLine)
+	}
+	return label
+}
+
+func (dump *Dump) cfgDot(sb *strings.Builder, p *Proto, indent string) {
+	node := func(start int) string { return fmt.Sprintf("p%d_%d", p.Index, start) }
+	for _, b := range dump.Blocks(p) {
+		var label strings.Builder
+		for pc := b.Start; pc < b.End; pc++ {
+			label.WriteString(dotQuote(dump.InsString(p, pc)) + `\l`)
+		}
+		fmt.Fprintf(sb, "%s%s [label=\"%s\"];\n", indent, node(b.Start), label.String())
+		for i, s := range b.Succs {
+			attrs := ""
+			if len(b.Succs) == 2 {
+				if i == 0 {
+					attrs = ` [label="jump"]`
+				} else {
+					attrs = ` [label="next"]`
+				}
+			}
+			fmt.Fprintf(sb, "%s%s -> %s%s;\n", indent, node(b.Start), node(s), attrs)
+		}
+	}
+}
+
+// CFGDot is a graphviz digraph of the control flow graph of proto index or all if -1
+func CFGDot(dump *Dump, index int) string {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n")
+	sb.WriteString("  node [shape=box fontname=monospace];\n")
+	for _, p := range dump.Protos {
+		if index != -1 && p.Index != index {
+			continue
+		}
+		if index != -1 {
+			fmt.Fprintf(&sb, "  label=\"%s\";\n", dump.protoLabel(p))
+			dump.cfgDot(&sb, p, "  ")
+			continue
+		}
+		fmt.Fprintf(&sb, "  subgraph cluster_%d {\n", p.Index)
+		fmt.Fprintf(&sb, "    label=\"%s\";\n", dump.protoLabel(p))
+		dump.cfgDot(&sb, p, "    ")
+		sb.WriteString("  }\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Callee is what a call instruction calls, a proto in the dump if it can
+// be resolved, otherwise global name if called by name
+type Callee struct {
+	PC     int
+	Proto  *Proto
+	Global string
+}
+
+// Calls resolves call targets of protos, best effort by following slots assigned
+// by FNEW, MOV, GGET of globals set to protos and UGET of upvalues that are
+// locals in the parent
+func (dump *Dump) Calls() map[*Proto][]Callee {
+	globals := map[string]*Proto{}
+	for _, p := range dump.Protos {
+		slots := map[int]*Proto{}
+		for pc, ins := range p.Ins {
+			switch dump.OpName(p, pc) {
+			case "FNEW":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Child != nil {
+					slots[int(ins.A)] = k.Child
+				}
+			case "GSET":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr && slots[int(ins.A)] != nil {
+					globals[k.Str] = slots[int(ins.A)]
+				}
+			}
+		}
+	}
+
+	calls := map[*Proto][]Callee{}
+	for _, p := range dump.Protos {
+		// slot to what it was last assigned, linear so ignores control flow
+		type value struct {
+			proto  *Proto
+			global string
+		}
+		slots := map[int]value{}
+		// parent slot values at the time the child was created
+		var parentSlots map[int]value
+		if p.Parent != nil {
+			parentSlots = map[int]value{}
+			for pc, ins := range p.Parent.Ins {
+				switch dump.OpName(p.Parent, pc) {
+				case "FNEW":
+					if k := p.Parent.KGCByD(int(ins.D)); k != nil && k.Child != nil {
+						parentSlots[int(ins.A)] = value{proto: k.Child}
+					}
+				}
+			}
+		}
+
+		for pc, ins := range p.Ins {
+			name := dump.OpName(p, pc)
+			a := int(ins.A)
+			switch name {
+			case "FNEW":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Child != nil {
+					slots[a] = value{proto: k.Child}
+					continue
+				}
+			case "MOV":
+				if v, ok := slots[int(ins.D)]; ok {
+					slots[a] = v
+					continue
+				}
+			case "GGET":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr {
+					slots[a] = value{proto: globals[k.Str], global: k.Str}
+					continue
+				}
+			case "UGET":
+				// local upvalue refers to a slot in parent
+				if int(ins.D) < len(p.UV) && p.UV[ins.D]&0x8000 != 0 && parentSlots != nil {
+					if v, ok := parentSlots[int(p.UV[ins.D]&0x3fff)]; ok {
+						slots[a] = v
+						continue
+					}
+				}
+			case "CALL", "CALLM", "CALLT", "CALLMT":
+				if v, ok := slots[a]; ok {
+					calls[p] = append(calls[p], Callee{PC: pc, Proto: v.proto, Global: v.global})
+				} else {
+					calls[p] = append(calls[p], Callee{PC: pc})
+				}
+			}
+			if dump.Opcodes.Get(int(ins.Op)).MA == BcMdst || dump.Opcodes.Get(int(ins.Op)).MA == BcMbase {
+				delete(slots, a)
+			}
+		}
+	}
+	return calls
+}
+
+// CallGraph is the call graph as jq values, one object per proto with the
+// protos it defines, its calls and the protos calling it
+func CallGraph(dump *Dump) []any {
+	calls := dump.Calls()
+	calledBy := map[*Proto][]any{}
+	for _, p := range dump.Protos {
+		seen := map[*Proto]bool{}
+		for _, c := range calls[p] {
+			if c.Proto != nil && !seen[c.Proto] {
+				seen[c.Proto] = true
+				calledBy[c.Proto] = append(calledBy[c.Proto], p.Index)
+			}
+		}
+	}
+
+	protos := []any{}
+	for _, p := range dump.Protos {
+		defines := []any{}
+		for _, c := range p.Children() {
+			defines = append(defines, c.Index)
+		}
+		pcalls := []any{}
+		for _, c := range calls[p] {
+			call := map[string]any{"pc": c.PC}
+			if c.Proto != nil {
+				call["proto"] = c.Proto.Index
+			}
+			if c.Global != "" {
+				call["global"] = c.Global
+			}
+			pcalls = append(pcalls, call)
+		}
+		cb := calledBy[p]
+		if cb == nil {
+			cb = []any{}
+		}
+		protos = append(protos, map[string]any{
+			"index":     p.Index,
+			"name":      dump.ProtoName(p),
+			"main":      p == dump.Main(),
+			"defines":   defines,
+			"calls":     pcalls,
+			"called_by": cb,
+		})
+	}
+	return protos
+}
+
+// CallGraphDot is a graphviz digraph with protos and globals called by name
+// as nodes, dashed edges from a proto to the protos it defines and solid
+// edges for calls
+func CallGraphDot(dump *Dump) string {
+	var sb strings.Builder
+	sb.WriteString("digraph callgraph {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, p := range dump.Protos {
+		fmt.Fprintf(&sb, "  p%d [label=\"%s\"];\n", p.Index, dump.protoLabel(p))
+	}
+
+	calls := dump.Calls()
+	globals := map[string]bool{}
+	for _, p := range dump.Protos {
+		for _, c := range p.Children() {
+			fmt.Fprintf(&sb, "  p%d -> p%d [style=dashed];\n", p.Index, c.Index)
+		}
+		seen := map[string]bool{}
+		for _, c := range calls[p] {
+			var to string
+			switch {
+			case c.Proto != nil:
+				to = fmt.Sprintf("p%d", c.Proto.Index)
+			case c.Global != "":
+				to = fmt.Sprintf("\"g_%s\"", dotQuote(c.Global))
+				globals[c.Global] = true
+			default:
+				continue
+			}
+			if seen[to] {
+				continue
+			}
+			seen[to] = true
+			fmt.Fprintf(&sb, "  p%d -> %s;\n", p.Index, to)
+		}
+	}
+
+	var names []string
+	for g := range globals {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		fmt.Fprintf(&sb, "  \"g_%s\" [label=\"%s\" shape=ellipse];\n", dotQuote(g), dotQuote(g))
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
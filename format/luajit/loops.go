@@ -0,0 +1,99 @@
+package luajit
+
+// loops from backward jumps, FORL, ITERL, and JMP or LOOP back to a
+// while/repeat condition
+
+import (
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_loops", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return LoopsReport(dump)
+	})
+}
+
+// Loop is the instruction indexes from the back edge target (header) to the
+// last back edge (end, inclusive)
+type Loop struct {
+	Header int
+	End    int
+	Op     string
+}
+
+// Loops finds loops by backward jumps, back edges to the same header are
+// one loop. Sorted by header with outer loops first
+func Loops(ins []Ins, opcodes BcDefList) []Loop {
+	byHeader := map[int]int{}
+	var loops []Loop
+	for pc, in := range ins {
+		def := opcodes.Get(int(in.Op))
+		if !def.IsJump() {
+			continue
+		}
+		t := in.Target(pc)
+		if t > pc || t < 0 {
+			continue
+		}
+		if i, ok := byHeader[t]; ok {
+			if pc > loops[i].End {
+				loops[i].End = pc
+				loops[i].Op = def.Name
+			}
+			continue
+		}
+		byHeader[t] = len(loops)
+		loops = append(loops, Loop{Header: t, End: pc, Op: def.Name})
+	}
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].Header != loops[j].Header {
+			return loops[i].Header < loops[j].Header
+		}
+		return loops[i].End > loops[j].End
+	})
+	return loops
+}
+
+// LoopAt is the nesting depth and innermost loop header for pc, header is -1
+// if not in a loop
+func LoopAt(loops []Loop, pc int) (depth int, header int) {
+	header = -1
+	for _, l := range loops {
+		if pc >= l.Header && pc <= l.End {
+			depth++
+			// sorted by header so the last one is the innermost
+			header = l.Header
+		}
+	}
+	return depth, header
+}
+
+// LoopsReport lists loops per proto with depth of the loop itself
+func LoopsReport(dump *Dump) []any {
+	loops := []any{}
+	for _, p := range dump.Protos {
+		pls := Loops(p.Ins, dump.Opcodes)
+		for _, l := range pls {
+			depth, _ := LoopAt(pls, l.Header)
+			r := map[string]any{
+				"proto":  p.Index,
+				"name":   dump.ProtoName(p),
+				"header": l.Header,
+				"end":    l.End,
+				"op":     l.Op,
+				"depth":  depth,
+			}
+			if len(p.LineInfo) == len(p.Ins) {
+				r["line"] = int(p.Line(l.Header))
+			}
+			loops = append(loops, r)
+		}
+	}
+	return loops
+}
@@ -24,6 +24,11 @@ import (
 	"bytes"
 	"embed"
 	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/decode"
@@ -42,16 +47,45 @@ func (m fallbackUintMapSymStr) MapUint(s scalar.Uint) (scalar.Uint, error) {
 	return m.UintMapSymStr.MapUint(s)
 }
 
+//go:embed luajit.jq
 //go:embed luajit.md
 var LuaJITFS embed.FS
 
+var probeGroup decode.Group
+
 func init() {
 	interp.RegisterFormat(
 		format.LuaJIT,
 		&decode.Format{
 			Description: "LuaJIT 2.0 bytecode",
-			Groups:      []*decode.Group{format.Probe},
+			Groups:      []*decode.Group{format.Probe, format.Lua},
 			DecodeFn:    LuaJITDecode,
+			Functions:   []string{"torepr"},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+			DefaultInArg: format.LuaJIT_In{
+				Strict:              false,
+				AllowUnknownVersion: false,
+				Dialect:             "",
+				Version:             0,
+				MaxItems:            1 << 20,
+				HeadersOnly:         false,
+				DecodeDebug:         false,
+				Variables:           false,
+				DecodeInstructions:  true,
+				Recover:             false,
+				NoHeader:            false,
+				NoHeaderFlags:       0,
+				WideInt:             "decimal",
+				FloatFormat:         "decimal",
+				ProbeTrailing:       false,
+				StringDisplayMax:    0,
+				NumberModel:         "auto",
+				MaxPrefix:           0,
+				Proto:               -1,
+				ProtoLast:           -1,
+			},
 		})
 	interp.RegisterFS(LuaJITFS)
 }
@@ -71,69 +105,628 @@ func u64tof64(u uint64) float64 {
 	return f
 }
 
+// float64 from its bit pattern, special values that normal constant folding
+// does not produce (nan, inf and -0) get a sym and keep the bit pattern as
+// description. float_format hex or bits sets the sym to a C %a style hex
+// float or the bit pattern, both are exact unlike the decimal value
+func (di *DumpInfo) numScalar(u uint64) scalar.Any {
+	f := u64tof64(u)
+	s := scalar.Any{Actual: f}
+
+	switch {
+	case math.IsNaN(f):
+		s.Sym = "nan"
+	case math.IsInf(f, 1):
+		s.Sym = "+inf"
+	case math.IsInf(f, -1):
+		s.Sym = "-inf"
+	case f == 0 && math.Signbit(f):
+		s.Sym = "-0"
+	}
+	if s.Sym != nil {
+		s.Description = fmt.Sprintf("%#016x", u)
+	}
+
+	switch di.Opts.FloatFormat {
+	case "hex":
+		if s.Sym == nil {
+			s.Sym = hexFloat(f)
+		}
+	case "bits":
+		s.Sym = fmt.Sprintf("%#016x", u)
+	}
+
+	return s
+}
+
+// as C printf %a, ex 0x1.8p+1 for 3
+func hexFloat(f float64) string {
+	h := strconv.FormatFloat(f, 'x', -1, 64)
+	// go uses at least two exponent digits
+	if i := strings.LastIndexAny(h, "+-"); i > 0 && h[i+1] == '0' && len(h) > i+2 {
+		h = h[:i+1] + h[i+2:]
+	}
+	return h
+}
+
+// non-DUALNUM builds write numbers that fit an int32 as int, -0 excluded
+func integralFloat(f float64) bool {
+	return f >= math.MinInt32 && f <= math.MaxInt32 && f == math.Trunc(f) && !(f == 0 && math.Signbit(f))
+}
+
+// int constants are stored as ULEB128 but are reinterpreted as a signed
+// 32 bit integer, ex: -1 is encoded as 0xffffffff
+func uleb128ToI32(u uint64) int64 {
+	return int64(int32(uint32(u)))
+}
+
+const (
+	versionLuaJIT20 = 1
+	versionLuaJIT21 = 2
+)
+
+var versionMap = scalar.UintMap{
+	versionLuaJIT20: {Sym: "2.0", Description: "LuaJIT 2.0"},
+	versionLuaJIT21: {Sym: "2.1", Description: "LuaJIT 2.1"},
+}
+
+var versionOpcodes = map[uint64]BcDefList{
+	versionLuaJIT20: opcodesLuaJIT20,
+	versionLuaJIT21: opcodesLuaJIT21,
+}
+
+// version option to header version
+var versionOptions = map[float64]uint64{
+	2.0: versionLuaJIT20,
+	2.1: versionLuaJIT21,
+}
+
+// opcode tables by name, OpenResty and moonjit keep the upstream 2.1 bytecode
+// so their names are aliases of it
+var dialectOpcodes = map[string]BcDefList{
+	"luajit2.0": opcodesLuaJIT20,
+	"luajit2.1": opcodesLuaJIT21,
+	"openresty": opcodesLuaJIT21,
+	"moonjit":   opcodesLuaJIT21,
+}
+
 type DumpInfo struct {
+	Version   uint64
 	Strip     bool
 	BigEndian bool
+	FFI       bool
+	FR2       bool
+	ChunkName string
+	Opcodes   BcDefList
+
+	Opts format.LuaJIT_In
+
+	Summary DumpSummary
+
+	// decoded proto indexes, child constants pop from it like lj_bcread does
+	ProtoStack []int
+	// flags of the proto being decoded
+	ProtoFlags uint64
+	// where in the proto being decoded, for error context
+	Pos ProtoPos
+	// GCproto in process memory where the first instruction is the function
+	// header
+	InMemory bool
+	// kgc of the proto being decoded parsed ahead for KCDATA, nil if unknown
+	ProtoKGC []KGC
+
+	Warnings []Warning
+}
+
+// Warning is a LuaJITWarn message and the path of the struct it is on
+type Warning struct {
+	Path    string
+	Message string
+}
+
+// ProtoPos is the instruction, kgc or knum index being decoded, -1 if not in
+// that part of the proto
+type ProtoPos struct {
+	Ins  int
+	KGC  int
+	KNum int
+}
+
+func (p ProtoPos) context(index int) string {
+	ctx := fmt.Sprintf("proto %d", index)
+	if p.Ins >= 0 {
+		ctx += fmt.Sprintf(", instruction %d", p.Ins)
+	}
+	if p.KGC >= 0 {
+		ctx += fmt.Sprintf(", kgc %d", p.KGC)
+	}
+	if p.KNum >= 0 {
+		ctx += fmt.Sprintf(", knum %d", p.KNum)
+	}
+	return ctx
+}
+
+// decode errors only have a bit position, prefix them with proto, instruction
+// and constant index to locate them in large dumps
+func (di *DumpInfo) withContext(index int, fn func()) {
+	di.Pos = ProtoPos{Ins: -1, KGC: -1, KNum: -1}
+	defer func() {
+		r := recover()
+		switch e := r.(type) {
+		case nil:
+			return
+		case decode.DecoderError:
+			e.Reason = di.Pos.context(index) + ": " + e.Reason
+			r = e
+		case decode.IOError:
+			e.Op = di.Pos.context(index) + ": " + e.Op
+			r = e
+		}
+		panic(r)
+	}()
+	fn()
+}
+
+// inconsistencies LuaJIT does not check when loading, errors if strict.
+// Added as warning on the struct being decoded, joined if there are more than
+// one, and collected for the top-level warnings
+func LuaJITWarn(di *DumpInfo, d *decode.D, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if di.Opts.Strict {
+		d.Errorf("%s", msg)
+	}
+	if v := d.FieldGet("warning"); v != nil {
+		if s, ok := v.V.(*scalar.Str); ok {
+			s.Actual += "; " + msg
+		}
+	} else {
+		d.FieldValueStr("warning", msg)
+	}
+	di.Warnings = append(di.Warnings, Warning{Path: valuePath(d.Value), Message: msg})
+}
+
+// jq path of a value being decoded relative to the format root, the value
+// and its parents are the last child of their parent while decoding
+func valuePath(v *decode.Value) string {
+	var parts []string
+	for ; v != nil && v.Parent != nil && v.Format == nil; v = v.Parent {
+		if c, ok := v.Parent.V.(*decode.Compound); ok && c.IsArray {
+			parts = append(parts, fmt.Sprintf("[%d]", len(c.Children)-1))
+		} else {
+			parts = append(parts, "."+v.Name)
+		}
+	}
+	var sb strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		sb.WriteString(parts[i])
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+func LuaJITDecodeWarnings(di *DumpInfo, d *decode.D) {
+	if len(di.Warnings) == 0 {
+		return
+	}
+	d.FieldArray("warnings", func(d *decode.D) {
+		for _, w := range di.Warnings {
+			d.FieldStruct("warning", func(d *decode.D) {
+				d.FieldValueStr("path", w.Path)
+				d.FieldValueStr("message", w.Message)
+			})
+		}
+	})
+}
+
+// counts while decoding for the summary struct
+type DumpSummary struct {
+	Protos       uint64
+	Instructions uint64
+	// by kind, child, tab, i64, u64, complex, str and num
+	Constants   map[string]uint64
+	StringBytes uint64
+	// float constants that fit an int32
+	IntegralFloats uint64
+	// ULEB128 with more bytes than needed
+	OverlongULEB uint64
+}
+
+var kgcKinds = []string{"child", "tab", "i64", "u64", "complex", "str"}
+
+func (s *DumpSummary) constant(kind string) {
+	if s.Constants == nil {
+		s.Constants = map[string]uint64{}
+	}
+	s.Constants[kind]++
+}
+
+// integral float constants are only written by DUALNUM builds (or other
+// producers), counted for number_model
+func (di *DumpInfo) integralFloat(s *scalar.Any) {
+	if f, ok := s.Actual.(float64); ok && integralFloat(f) {
+		di.Summary.IntegralFloats++
+		s.Description = "integral float, written by DUALNUM builds"
+	}
+}
+
+// ULEB128 with as few bytes as possible like LuaJIT writes it
+func ulebSize(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+func appendDescription(desc *string, s string) {
+	if *desc != "" {
+		*desc += ", "
+	}
+	*desc += s
+}
+
+// ULEB128 with encodings longer than needed counted for the summary and
+// noted in desc, padding with continuation bytes breaks naive parsers
+func (di *DumpInfo) ULEB128(d *decode.D, desc *string) uint64 {
+	start := d.Pos()
+	v := d.ULEB128()
+	if n, min := int((d.Pos()-start)/8), ulebSize(v); n > min {
+		di.Summary.OverlongULEB++
+		msg := fmt.Sprintf("overlong ULEB128, %d bytes instead of %d", n, min)
+		if di.Opts.Strict {
+			d.Errorf("%s", msg)
+		}
+		appendDescription(desc, msg)
+	}
+	return v
+}
+
+func LuaJITFieldULEB128(di *DumpInfo, d *decode.D, name string, sms ...scalar.UintMapper) uint64 {
+	return d.FieldUintScalarFn(name, func(d *decode.D) scalar.Uint {
+		s := scalar.Uint{}
+		s.Actual = di.ULEB128(d, &s.Description)
+		return s
+	}, sms...)
+}
+
+// opcode table from dialect option or version
+func (di *DumpInfo) SetOpcodes() {
+	switch {
+	case di.Opts.Dialect != "":
+		di.Opcodes = dialectOpcodes[di.Opts.Dialect]
+	case versionOpcodes[di.Version] != nil:
+		di.Opcodes = versionOpcodes[di.Version]
+	default:
+		di.Opcodes = opcodesLuaJIT21
+	}
+}
+
+// string_display_max truncates long strings, the full string is still
+// available as the range using tobytes
+func (di *DumpInfo) StrDisplay() scalar.StrMapper {
+	return scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
+		max := int(di.Opts.StringDisplayMax)
+		if max == 0 || len(s.Actual) <= max {
+			return s, nil
+		}
+		t := s.Actual[:max]
+		// do not cut in the middle of a rune
+		for len(t) > 0 && !utf8.ValidString(t) {
+			t = t[:len(t)-1]
+		}
+		s.Description = fmt.Sprintf("truncated from %d bytes", len(s.Actual))
+		s.Actual = t
+		return s, nil
+	})
+}
+
+func (di *DumpInfo) SetFlags(flags uint64) {
+	di.Strip = flags&0x2 > 0
+	di.BigEndian = flags&0x1 > 0
+	di.FFI = flags&0x4 > 0
+	di.FR2 = flags&0x8 > 0
 }
 
 func LuaJITDecodeHeader(di *DumpInfo, d *decode.D) {
 	d.FieldRawLen("magic", 3*8, d.AssertBitBuf([]byte{0x1b, 0x4c, 0x4a})) // ESC 'L' 'J'
 
-	d.FieldU8("version")
+	di.Version = d.FieldU8("version", versionMap)
+	// version byte is sometimes changed to break tools
+	if v, ok := versionOptions[di.Opts.Version]; ok {
+		if v != di.Version {
+			d.FieldValueUint("version_override", v, versionMap, scalar.UintDescription("from version option"))
+		}
+		di.Version = v
+	} else if _, ok := versionMap[di.Version]; !ok && !di.Opts.AllowUnknownVersion {
+		d.Errorf("unknown version %d", di.Version)
+	}
+
+	di.SetOpcodes()
 
 	var flags uint64
 	d.FieldStruct("flags", func(d *decode.D) {
-		flags = d.FieldULEB128("raw")
+		flags = LuaJITFieldULEB128(di, d, "raw")
 
 		d.FieldValueBool("be", flags&0x01 > 0)
 		d.FieldValueBool("strip", flags&0x02 > 0)
 		d.FieldValueBool("ffi", flags&0x04 > 0)
 		d.FieldValueBool("fr2", flags&0x08 > 0)
+		// forks and future versions can add flags, LuaJIT rejects dumps with flags it
+		// does not know
+		if unknown := flags &^ dumpFlagsKnown; unknown != 0 {
+			d.FieldValueUint("unknown", unknown, scalar.UintHex)
+			LuaJITWarn(di, d, "unknown flag bits %#x", unknown)
+		}
 	})
-
-	di.Strip = flags&0x2 > 0
-	di.BigEndian = flags&0x1 > 0
+	di.SetFlags(flags)
 
 	if !di.Strip {
-		namelen := d.FieldU8("namelen")
-		d.FieldUTF8("name", int(namelen))
+		namelen := LuaJITFieldULEB128(di, d, "namelen")
+		if left := uint64(d.BitsLeft() / 8); namelen > left {
+			d.Fatalf("name length %d does not fit in remaining %d bytes", namelen, left)
+		}
+		name := d.FieldUTF8("name", int(namelen))
+		di.ChunkName = LuaJITDecodeChunkName(name, d)
 	}
 }
 
-type jumpBias struct{}
+// see lua_load chunkname, "@" file name, "=" custom name, otherwise the source itself
+// returns name without prefix
+func LuaJITDecodeChunkName(name string, d *decode.D) string {
+	var value string
+	d.FieldStruct("chunkname", func(d *decode.D) {
+		switch {
+		case strings.HasPrefix(name, "@"):
+			d.FieldValueStr("kind", "file")
+			value = name[1:]
+		case strings.HasPrefix(name, "="):
+			d.FieldValueStr("kind", "custom")
+			value = name[1:]
+		default:
+			d.FieldValueStr("kind", "source")
+			value = name
+		}
+		d.FieldValueStr("value", value)
+	})
+	return value
+}
+
+// primitive operands, KPRI, ISEQP, ISNEP and USETP D
+var priSyms = scalar.UintMapSymStr{
+	0: "nil",
+	1: "false",
+	2: "true",
+}
+
+// ISTYPE and ISNUM D is the negated internal type tag, -LJ_TNIL etc, as Lua
+// type names, false and true have their own tags
+var typeSyms = scalar.UintMap{
+	1:  {Sym: "nil", Description: "LJ_TNIL"},
+	2:  {Sym: "boolean", Description: "LJ_TFALSE"},
+	3:  {Sym: "boolean", Description: "LJ_TTRUE"},
+	4:  {Sym: "userdata", Description: "LJ_TLIGHTUD"},
+	5:  {Sym: "string", Description: "LJ_TSTR"},
+	6:  {Sym: "upvalue", Description: "LJ_TUPVAL"},
+	7:  {Sym: "thread", Description: "LJ_TTHREAD"},
+	8:  {Sym: "proto", Description: "LJ_TPROTO"},
+	9:  {Sym: "function", Description: "LJ_TFUNC"},
+	10: {Sym: "trace", Description: "LJ_TTRACE"},
+	11: {Sym: "cdata", Description: "LJ_TCDATA"},
+	12: {Sym: "table", Description: "LJ_TTAB"},
+	13: {Sym: "userdata", Description: "LJ_TUDATA"},
+	14: {Sym: "number", Description: "LJ_TNUMX"},
+}
 
-func (j *jumpBias) MapUint(u scalar.Uint) (scalar.Uint, error) {
-	u.Actual -= 0x8000
-	return u, nil
+// result and argument counts are stored plus one, 0 results means all results
+// which sets MULTRES and the M variants add MULTRES from a previous call or VARG
+func countOperand(name string, operand string) scalar.UintMapper {
+	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		n := int64(s.Actual)
+		switch name + " " + operand {
+		case "CALL b", "CALLM b", "VARG b":
+			if n == 0 {
+				s.Description = "all results, sets MULTRES"
+			} else {
+				s.Description = fmt.Sprintf("%d results", n-1)
+			}
+		case "ITERC b", "ITERN b":
+			s.Description = fmt.Sprintf("%d results", n-1)
+		case "VARG c":
+			s.Description = fmt.Sprintf("%d fixed params", n)
+		case "CALL c", "CALLT d":
+			s.Description = fmt.Sprintf("%d args", n-1)
+		case "CALLM c", "CALLMT d":
+			s.Description = fmt.Sprintf("%d args + MULTRES", n)
+		case "RET d":
+			s.Description = fmt.Sprintf("%d results", n-1)
+		case "RETM d":
+			s.Description = fmt.Sprintf("%d results + MULTRES", n)
+		case "TSETM d":
+			s.Description = "first index from knum D, MULTRES values"
+		}
+		return s, nil
+	})
 }
 
-func LuaJITDecodeBCIns(d *decode.D) {
-	op := d.FieldU8("op", opcodes)
+var uvDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	uv := uint16(s.Actual)
+	if uv&uvFlagLocal != 0 {
+		s.Description = fmt.Sprintf("parent slot %d", uvIndex(uv))
+	} else {
+		s.Description = fmt.Sprintf("parent upvalue %d", uvIndex(uv))
+	}
+	if uv&uvFlagImmutable != 0 {
+		s.Description += ", immutable"
+	}
+	return s, nil
+})
 
-	d.FieldU8("a")
+// the instruction word is stored in dump byte order, so the fields are laid out as
+//
+//	little endian: op a c b | op a d
+//	big endian:    b c a op | d a op
+func LuaJITDecodeBCIns(di *DumpInfo, d *decode.D) {
+	// same pc as the luajit_* functions, byte offset in the input
+	d.FieldValueUint("pc", uint64(di.Pos.Ins))
+	d.FieldValueUint("offset", uint64(d.Pos()/8), scalar.UintHex)
+
+	// word as LuaJIT sees it in memory, op | a<<8 | c<<16 | b<<24 or op | a<<8 | d<<16
+	bs := d.PeekBytes(4)
+	if di.BigEndian {
+		d.FieldValueUint("word", uint64(binary.BigEndian.Uint32(bs)), scalar.UintHex)
+	} else {
+		d.FieldValueUint("word", uint64(binary.LittleEndian.Uint32(bs)), scalar.UintHex)
+	}
 
-	if opcodes[int(op)].HasD() {
-		if opcodes[int(op)].IsJump() {
-			d.FieldU16("j", &jumpBias{})
+	if di.BigEndian {
+		// op is the last byte of the word, peek it to know the operand layout
+		op := d.PeekUintBits(32) & 0xff
+
+		if di.Opcodes.Get(int(op)).HasD() {
+			LuaJITDecodeBCInsD(di, int(op), d)
 		} else {
-			d.FieldU16("d")
+			d.FieldU8("b", countOperand(di.Opcodes.Get(int(op)).Name, "b"))
+			d.FieldU8("c", countOperand(di.Opcodes.Get(int(op)).Name, "c"))
 		}
+		LuaJITDecodeBCInsA(di, int(op), d)
+		d.FieldU8("op", di.Opcodes)
 	} else {
-		d.FieldU8("c")
-		d.FieldU8("b")
+		op := d.FieldU8("op", di.Opcodes)
+		LuaJITDecodeBCInsA(di, int(op), d)
+
+		if di.Opcodes.Get(int(op)).HasD() {
+			LuaJITDecodeBCInsD(di, int(op), d)
+		} else {
+			d.FieldU8("c", countOperand(di.Opcodes.Get(int(op)).Name, "c"))
+			d.FieldU8("b", countOperand(di.Opcodes.Get(int(op)).Name, "b"))
+		}
 	}
+
+	op := bs[0]
+	if di.BigEndian {
+		op = bs[3]
+	}
+	def := di.Opcodes.Get(int(op))
+	if category := def.Category(); category != "" {
+		d.FieldValueStr("category", category)
+	}
+	if def.Name == "KCDATA" {
+		w := binary.LittleEndian.Uint32(bs)
+		if di.BigEndian {
+			w = binary.BigEndian.Uint32(bs)
+		}
+		LuaJITDecodeKCData(di, int(w>>16), d)
+	}
+
+	// the function header is not in the dump, and VARG in a fixed argument
+	// function reads outside the frame
+	switch {
+	case def.Category() == "function_header" && !(di.InMemory && di.Pos.Ins == 0):
+		LuaJITWarn(di, d, "function header in body, LuaJIT adds FUNCF or FUNCV when loading")
+	case def.Name == "VARG" && di.ProtoFlags&protoFlagVararg == 0:
+		LuaJITWarn(di, d, "VARG in proto without vararg flag")
+	}
+}
+
+// cdata constant of a KCDATA instruction, constants are after the
+// instructions so they are parsed ahead
+func LuaJITDecodeKCData(di *DumpInfo, op int, d *decode.D) {
+	if op >= len(di.ProtoKGC) {
+		return
+	}
+	k := di.ProtoKGC[len(di.ProtoKGC)-1-op]
+	switch k.Type {
+	case kgcI64:
+		d.FieldValueStr("cdata_type", "i64")
+		d.FieldValueSint("cdata_value", k.I64, scalar.SintSym(wideSym(di, k.U64, strconv.FormatInt(k.I64, 10))))
+	case kgcU64:
+		d.FieldValueStr("cdata_type", "u64")
+		d.FieldValueUint("cdata_value", k.U64, scalar.UintSym(wideSym(di, k.U64, strconv.FormatUint(k.U64, 10))))
+	case kgcComplex:
+		d.FieldValueStr("cdata_type", "complex")
+		d.FieldValueStr("cdata_value", complexString(k.Real, k.Imag))
+	}
+}
+
+// kgc of a proto from after the instructions and upvalues, nil if damaged
+func peekKGC(di *DumpInfo, d *decode.D, numbc uint64, numuv uint64, numkgc uint64) []KGC {
+	r := &dumpReader{buf: d.PeekBytes(int(d.BitsLeft() / 8)), be: di.BigEndian}
+	r.bytes(numbc*4 + numuv*2)
+	n := r.count(numkgc, 1)
+	kgc := make([]KGC, 0, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		kgc = append(kgc, r.kgc())
+	}
+	if r.err != nil || uint64(len(kgc)) != numkgc {
+		return nil
+	}
+	return kgc
 }
 
-func LuaJITDecodeNum(d *decode.D) {
-	d.FieldAnyFn("value", func(d *decode.D) any {
-		lo := d.ULEB128()
-		hi := d.ULEB128()
-		return u64tof64((hi << 32) + lo)
+// with fr2 (two slot frame links, default for 64 bit LuaJIT 2.1) there is an
+// extra slot between the called function and its first argument
+func LuaJITDecodeBCInsA(di *DumpInfo, op int, d *decode.D) {
+	switch di.Opcodes.Get(op).Name {
+	case "CALLM", "CALL", "CALLMT", "CALLT":
+		if di.FR2 {
+			d.FieldU8("a", scalar.UintDescription("func, args from A+2"))
+		} else {
+			d.FieldU8("a", scalar.UintDescription("func, args from A+1"))
+		}
+	default:
+		d.FieldU8("a")
+	}
+}
+
+func LuaJITDecodeBCInsD(di *DumpInfo, op int, d *decode.D) {
+	if di.Opcodes.Get(op).IsJump() {
+		// j is stored biased by 0x8000, relative to the next instruction
+		j := d.FieldSintFn("j", func(d *decode.D) int64 { return int64(d.U16()) - 0x8000 })
+		// backward jumps are loops (or obfuscation)
+		if j < 0 {
+			d.FieldValueStr("direction", "backward")
+		} else {
+			d.FieldValueStr("direction", "forward")
+		}
+	} else if di.Opcodes.Get(op).IsLits() {
+		d.FieldS16("d")
+	} else if di.Opcodes.Get(op).MC == BcMpri {
+		d.FieldU16("d", priSyms)
+	} else if name := di.Opcodes.Get(op).Name; name == "ISTYPE" || name == "ISNUM" {
+		d.FieldU16("d", typeSyms)
+	} else {
+		d.FieldU16("d", countOperand(di.Opcodes.Get(op).Name, "d"))
+	}
+}
+
+func LuaJITDecodeNum(di *DumpInfo, narrow bool, d *decode.D) float64 {
+	var f float64
+	var bits uint64
+	d.FieldAnyScalarFn("value", func(d *decode.D) scalar.Any {
+		var desc string
+		lo := di.ULEB128(d, &desc)
+		hi := di.ULEB128(d, &desc)
+		bits = joinHalves(lo, hi)
+		s := di.numScalar(bits)
+		f = s.Actual.(float64)
+		if narrow {
+			di.integralFloat(&s)
+		}
+		if desc != "" {
+			appendDescription(&s.Description, desc)
+		}
+		return s
 	})
+	d.FieldValueStr("value_bits", numBits(bits))
+	return f
 }
 
-func LuaJITDecodeKTabK(d *decode.D) {
-	ktabtype := d.FieldULEB128("type", fallbackUintMapSymStr{
+// LuaJITDecodeKTabK returns the value as nil, bool, int64, float64 or string,
+// narrow is false for hash keys which LuaJIT writes without narrowing to int
+func LuaJITDecodeKTabK(di *DumpInfo, narrow bool, d *decode.D) any {
+	ktabtype := LuaJITFieldULEB128(di, d, "type", fallbackUintMapSymStr{
 		fallback: "str",
 		UintMapSymStr: scalar.UintMapSymStr{
 			0: "nil",
@@ -148,80 +741,197 @@ func LuaJITDecodeKTabK(d *decode.D) {
 	case 0:
 		// nil
 		d.FieldValueAny("value", nil)
+		return nil
 
 	case 1:
 		// false
 		d.FieldValueBool("value", false)
+		return false
 
 	case 2:
 		// true
 		d.FieldValueBool("value", true)
+		return true
 
 	case 3:
-		// int
-		d.FieldULEB128("value")
+		// int, truncated to int32 when loaded
+		return d.FieldSintScalarFn("value", func(d *decode.D) scalar.Sint {
+			s := scalar.Sint{}
+			u := di.ULEB128(d, &s.Description)
+			s.Actual = uleb128ToI32(u)
+			if u > math.MaxUint32 {
+				appendDescription(&s.Description, fmt.Sprintf("truncated from %d", u))
+			}
+			return s
+		})
 
 	case 4:
-		LuaJITDecodeNum(d)
+		return LuaJITDecodeNum(di, narrow, d)
 
 	// ktabtype >= 5
 	default:
 		// str
 		size := ktabtype - 5
-		d.FieldUTF8("value", int(size))
+		class := StrClass(LuaJITPeekStr(d, size))
+		v := d.FieldUTF8("value", int(size), di.StrDisplay())
+		d.FieldValueStr("class", class)
+		return v
+	}
+}
+
+// table key as a string, lua tostring for numbers and booleans
+func luaKeyString(k any) string {
+	switch k := k.(type) {
+	case string:
+		return k
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case float64:
+		return luaNumString(k)
+	case bool:
+		return strconv.FormatBool(k)
+	default:
+		return "nil"
 	}
 }
 
-func LuaJITDecodeTab(d *decode.D) {
-	narray := d.FieldULEB128("narray")
-	nhash := d.FieldULEB128("nhash")
+func LuaJITDecodeTab(di *DumpInfo, d *decode.D) {
+	narray := LuaJITFieldULEB128(di, d, "narray")
+	nhash := LuaJITFieldULEB128(di, d, "nhash")
+	LuaJITCheckCount(di, d, "narray", narray, 1)
+	LuaJITCheckCount(di, d, "nhash", nhash, 2)
 
+	var array []any
 	d.FieldArray("array", func(d *decode.D) {
 		for i := uint64(0); i < narray; i++ {
-			d.FieldStruct("element", LuaJITDecodeKTabK)
+			d.FieldStruct("element", func(d *decode.D) {
+				array = append(array, LuaJITDecodeKTabK(di, true, d))
+			})
 		}
 	})
 
+	var hash [][2]any
 	d.FieldArray("hash", func(d *decode.D) {
 		for i := uint64(0); i < nhash; i++ {
 			d.FieldStruct("pair", func(d *decode.D) {
-				d.FieldStruct("key", LuaJITDecodeKTabK)
-				d.FieldStruct("value", LuaJITDecodeKTabK)
+				var kv [2]any
+				d.FieldStruct("key", func(d *decode.D) { kv[0] = LuaJITDecodeKTabK(di, false, d) })
+				d.FieldStruct("value", func(d *decode.D) { kv[1] = LuaJITDecodeKTabK(di, true, d) })
+				hash = append(hash, kv)
 			})
 		}
 	})
+
+	LuaJITDecodeTableValue(d, array, hash)
 }
 
-func LuaJITDecodeI64(d *decode.D) int64 {
-	lo := d.ULEB128()
-	hi := d.ULEB128()
-	return int64((hi << 32) + lo)
+// table as an array if it is a sequence (array part from index 1 and no hash
+// part) otherwise as a struct with keys stringified, nil values are skipped as
+// they are not stored in a lua table
+func LuaJITDecodeTableValue(d *decode.D, array []any, hash [][2]any) {
+	if len(hash) == 0 && (len(array) == 0 || array[0] == nil) {
+		d.FieldArray("table_value", func(d *decode.D) {
+			for i := 1; i < len(array); i++ {
+				d.FieldValueAny("element", luaJQValue(array[i]))
+			}
+		})
+		return
+	}
+
+	seen := map[string]bool{}
+	d.FieldStruct("table_value", func(d *decode.D) {
+		field := func(k string, v any) {
+			if v == nil || seen[k] {
+				return
+			}
+			seen[k] = true
+			d.FieldValueAny(k, luaJQValue(v))
+		}
+		for i, v := range array {
+			field(strconv.Itoa(i), v)
+		}
+		for _, kv := range hash {
+			field(luaKeyString(kv[0]), kv[1])
+		}
+	})
+}
+
+func luaJQValue(v any) any {
+	if i, ok := v.(int64); ok {
+		return int(i)
+	}
+	return v
 }
 
-func LuaJITDecodeU64(d *decode.D) uint64 {
-	lo := d.ULEB128()
-	hi := d.ULEB128()
-	return (hi << 32) + lo
+// 64 bit cdata constants are two ULEB128 that are each truncated to 32 bits
+// when loaded
+func LuaJITDecodeWide(di *DumpInfo, d *decode.D) (uint64, string) {
+	desc := ""
+	lo := di.ULEB128(d, &desc)
+	hi := di.ULEB128(d, &desc)
+	if lo > math.MaxUint32 || hi > math.MaxUint32 {
+		appendDescription(&desc, fmt.Sprintf("truncated from lo %d hi %d", lo, hi))
+	}
+	return joinHalves(lo, hi), desc
+}
+
+// as number, or for wide_int hex or string as hex or decimal string to
+// survive conversion to JSON numbers
+func wideSym(di *DumpInfo, u uint64, s string) any {
+	switch di.Opts.WideInt {
+	case "hex":
+		return fmt.Sprintf("%#016x", u)
+	case "string":
+		return s
+	default:
+		return nil
+	}
 }
 
-func LuaJITDecodeComplex(d *decode.D) {
-	d.FieldAnyFn("real", func(d *decode.D) any {
-		rlo := d.ULEB128()
-		rhi := d.ULEB128()
-		r := (rhi << 32) + rlo
-		return u64tof64(r)
+func LuaJITDecodeI64(di *DumpInfo, d *decode.D) int64 {
+	return d.FieldSintScalarFn("value", func(d *decode.D) scalar.Sint {
+		u, desc := LuaJITDecodeWide(di, d)
+		i := int64(u)
+		return scalar.Sint{Actual: i, Sym: wideSym(di, u, strconv.FormatInt(i, 10)), Description: desc}
 	})
+}
+
+func LuaJITDecodeU64(di *DumpInfo, d *decode.D) uint64 {
+	return d.FieldUintScalarFn("value", func(d *decode.D) scalar.Uint {
+		u, desc := LuaJITDecodeWide(di, d)
+		return scalar.Uint{Actual: u, Sym: wideSym(di, u, strconv.FormatUint(u, 10)), Description: desc}
+	})
+}
+
+// complex as "re+imi" like tostring of a complex cdata
+func complexString(re float64, im float64) string {
+	sign := "+"
+	if im < 0 || (im == 0 && math.Signbit(im)) {
+		sign = "-"
+		im = -im
+	}
+	return luaNumString(re) + sign + luaNumString(im) + "i"
+}
 
-	d.FieldAnyFn("imag", func(d *decode.D) any {
-		ilo := d.ULEB128()
-		ihi := d.ULEB128()
-		i := (ihi << 32) + ilo
-		return u64tof64(i)
+func LuaJITDecodeComplex(di *DumpInfo, d *decode.D) {
+	var bits [2]uint64
+	d.FieldAnyScalarFn("real", func(d *decode.D) scalar.Any {
+		bits[0], _ = LuaJITDecodeWide(di, d)
+		return di.numScalar(bits[0])
+	})
+	d.FieldAnyScalarFn("imag", func(d *decode.D) scalar.Any {
+		bits[1], _ = LuaJITDecodeWide(di, d)
+		return di.numScalar(bits[1])
 	})
+
+	d.FieldValueStr("text", complexString(u64tof64(bits[0]), u64tof64(bits[1])))
+	// as strings as they do not survive conversion to JSON numbers
+	d.FieldValueStr("real_bits", numBits(bits[0]))
+	d.FieldValueStr("imag_bits", numBits(bits[1]))
 }
 
-func LuaJITDecodeKGC(d *decode.D) {
-	kgctype := d.FieldULEB128("type", fallbackUintMapSymStr{
+func LuaJITDecodeKGC(di *DumpInfo, d *decode.D) {
+	kgctype := LuaJITFieldULEB128(di, d, "type", fallbackUintMapSymStr{
 		fallback: "str",
 		UintMapSymStr: scalar.UintMapSymStr{
 			0: "child",
@@ -231,34 +941,62 @@ func LuaJITDecodeKGC(d *decode.D) {
 			4: "complex",
 		},
 	})
+	if kgctype < kgcStr {
+		di.Summary.constant(kgcKinds[kgctype])
+	} else {
+		di.Summary.constant("str")
+		di.Summary.StringBytes += kgctype - kgcStr
+	}
+
+	// cdata constants need the ffi to be loaded which only happens if the header says so
+	if kgctype >= 2 && kgctype <= 4 && !di.FFI {
+		LuaJITWarn(di, d, "cdata constant without ffi header flag")
+	}
 
 	switch kgctype {
 	case 0:
 		// child
+		if n := len(di.ProtoStack); n > 0 {
+			index := di.ProtoStack[n-1]
+			di.ProtoStack = di.ProtoStack[:n-1]
+			d.FieldValueUint("proto", uint64(index))
+			d.FieldValueStr("path", fmt.Sprintf(".proto[%d]", index))
+		} else {
+			LuaJITWarn(di, d, "child constant without a proto before it")
+		}
 
 	case 1:
-		LuaJITDecodeTab(d)
+		LuaJITDecodeTab(di, d)
 
 	case 2:
-		LuaJITDecodeI64(d)
+		LuaJITDecodeI64(di, d)
 
 	case 3:
-		LuaJITDecodeU64(d)
+		LuaJITDecodeU64(di, d)
 
 	case 4:
 		// json does not support complex numbers,
 		// so we use a struct{real: float64, imag: float64}
-		d.FieldStruct("value", LuaJITDecodeComplex)
+		d.FieldStruct("value", func(d *decode.D) { LuaJITDecodeComplex(di, d) })
 
 	// kgctype >= 5
 	default:
 		// str
 		size := kgctype - 5
-		d.FieldUTF8("value", int(size))
+		class := StrClass(LuaJITPeekStr(d, size))
+		d.FieldUTF8("value", int(size), di.StrDisplay())
+		d.FieldValueStr("class", class)
 	}
 }
 
-func LuaJITDecodeKNum(d *decode.D) any {
+// float64 bit pattern as a string as it does not survive conversion to a JSON
+// number, for exact comparisons and payloads hidden in nan
+func numBits(u uint64) string {
+	return fmt.Sprintf("%#016x", u)
+}
+
+// LuaJITDecodeKNum sets bits to the bit pattern of a float, an int has none
+func LuaJITDecodeKNum(di *DumpInfo, d *decode.D, bits *any) scalar.Any {
 	// knum = intU0 | (loU1 hiU)
 	// ...
 	// W = 32 bit, U = ULEB128 of W, U0/U1 = ULEB128 of W+1
@@ -267,40 +1005,52 @@ func LuaJITDecodeKNum(d *decode.D) any {
 	// loU1 encodes 33 bits : the lower half of a float64, plus the LSB=1
 	// hiU encodes 32 bits : the higher half of the float64
 
-	lo := d.ULEB128()
+	var desc string
+	lo := di.ULEB128(d, &desc)
 	if lo&1 == 0 {
-		// we have an int32 (aka LuaJIT 'int')
-
-		// drop the LSB
-		lo_data := lo >> 1
-
-		// downcast to 32bits. should not overflow
-		lo_data_u32 := uint32(lo_data)
-
-		// make it a signed integer
-		lo_data_i32 := int32(lo_data_u32)
-
-		// return a larger type to make fq happy
-		return int64(lo_data_i32)
+		// we have an int32 (aka LuaJIT 'int'), drop the LSB
+		return scalar.Any{Actual: uleb128ToI32(lo >> 1), Description: desc}
 	} else {
 		// we have float64 (aka LuaJIT 'number')
 
-		hi := d.ULEB128()
-		return u64tof64((hi << 32) + (lo >> 1))
+		hi := di.ULEB128(d, &desc)
+		u := joinHalves(lo>>1, hi)
+		*bits = numBits(u)
+		s := di.numScalar(u)
+		di.integralFloat(&s)
+		if desc != "" {
+			appendDescription(&s.Description, desc)
+		}
+		return s
 	}
 }
 
-func LuaJITDecodeDebug(d *decode.D, debuglen uint64, numbc uint64) {
+// line info entries are 1, 2 or 4 bytes depending on numline and are
+// relative to firstline, sym is the line
+func LuaJITDecodeDebug(d *decode.D, debuglen uint64, numbc uint64, firstline uint64, numline uint64) {
+	width := 4
+	switch {
+	case numline < 256:
+		width = 1
+	case numline < 65536:
+		width = 2
+	}
+	line := scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		s.Sym = firstline + s.Actual
+		return s, nil
+	})
+
 	d.FieldStruct("debug", func(d *decode.D) {
+		d.FieldValueUint("line_width", uint64(width))
 		d.FieldArray("lines", func(d *decode.D) {
 			for i := uint64(0); i < numbc; i++ {
-				d.FieldU8("value")
+				d.FieldU("value", width*8, line)
 			}
 		})
 
 		// TODO: find out more about how to decode these strings
 		d.FieldArray("annotations", func(d *decode.D) {
-			i := numbc
+			i := numbc * uint64(width)
 			for i < debuglen {
 				str := d.FieldUTF8Null("value")
 				i += uint64(len(str) + 1)
@@ -309,8 +1059,40 @@ func LuaJITDecodeDebug(d *decode.D, debuglen uint64, numbc uint64) {
 	})
 }
 
-func LuaJITDecodeProto(di *DumpInfo, d *decode.D) {
-	length := d.FieldULEB128("length")
+// string constant bytes before decoding it, a corrupt length fails instead of allocating
+func LuaJITPeekStr(d *decode.D, size uint64) string {
+	if left := uint64(d.BitsLeft() / 8); size > left {
+		d.Fatalf("string length %d does not fit in remaining %d bytes", size, left)
+	}
+	return string(d.PeekBytes(int(size)))
+}
+
+// counts are read from the dump, check them against the minimum size the
+// items need and max_items so that a small crafted dump can't cause lots of work
+func LuaJITCheckCount(di *DumpInfo, d *decode.D, name string, n uint64, minSize uint64) {
+	left := uint64(d.BitsLeft() / 8)
+	if n > left/minSize {
+		d.Fatalf("%s %d does not fit in remaining %d bytes", name, n, left)
+	}
+	if di.Opts.MaxItems > 0 && n > di.Opts.MaxItems {
+		d.Fatalf("%s %d larger than max_items %d", name, n, di.Opts.MaxItems)
+	}
+}
+
+func LuaJITDecodeProto(di *DumpInfo, index int, d *decode.D) {
+	d.FieldValueUint("index", uint64(index))
+	length := LuaJITFieldULEB128(di, d, "length")
+	var firstline uint64
+	var numline uint64
+	var flags uint64
+	var numparams uint64
+	var firstOp byte
+	// debug info parsed ahead as upvalue names are needed before it
+	var dbg *Proto
+	hasDebug := false
+	if left := uint64(d.BitsLeft() / 8); length > left {
+		d.Fatalf("length %d does not fit in remaining %d bytes", length, left)
+	}
 
 	d.LimitedFn(8*int64(length), func(d *decode.D) {
 		d.FieldStruct("pdata", func(d *decode.D) {
@@ -321,65 +1103,304 @@ func LuaJITDecodeProto(di *DumpInfo, d *decode.D) {
 			var debuglen uint64
 
 			d.FieldStruct("phead", func(d *decode.D) {
-				d.FieldU8("flags")
-				d.FieldU8("numparams")
-				d.FieldU8("framesize")
+				flags = d.FieldU8("flags")
+				di.ProtoFlags = flags
+				numparams = d.FieldU8("numparams")
+				var framesize uint64
+				if di.FR2 {
+					framesize = d.FieldU8("framesize", scalar.UintDescription("includes 2 slot call frames"))
+				} else {
+					framesize = d.FieldU8("framesize")
+				}
+				// not in the dump, LuaJIT adds it from the vararg flag when loading
+				if flags&protoFlagVararg != 0 {
+					d.FieldValueStr("function_header", "FUNCV", scalar.StrDescription("added when loading"))
+				} else {
+					d.FieldValueStr("function_header", "FUNCF", scalar.StrDescription("added when loading"))
+				}
+				if numparams > framesize {
+					LuaJITWarn(di, d, "numparams %d larger than framesize %d", numparams, framesize)
+				}
+				// bcwrite sets the header ffi flag if any proto has it
+				if flags&protoFlagFFI != 0 && !di.FFI {
+					LuaJITWarn(di, d, "proto ffi flag without ffi header flag")
+				}
 				numuv = d.FieldU8("numuv")
-				numkgc = d.FieldULEB128("numkgc")
-				numkn = d.FieldULEB128("numkn")
-				numbc = d.FieldULEB128("numbc")
+				numkgc = LuaJITFieldULEB128(di, d, "numkgc")
+				numkn = LuaJITFieldULEB128(di, d, "numkn")
+				numbc = LuaJITFieldULEB128(di, d, "numbc")
+				di.Summary.Protos++
+				di.Summary.Instructions += numbc
 
 				debuglen = 0
 				if !di.Strip {
-					debuglen = d.FieldULEB128("debuglen")
+					debuglen = LuaJITFieldULEB128(di, d, "debuglen")
 					if debuglen > 0 {
-						d.FieldULEB128("firstline")
-						d.FieldULEB128("numline")
+						hasDebug = true
+						firstline = LuaJITFieldULEB128(di, d, "firstline")
+						numline = LuaJITFieldULEB128(di, d, "numline")
 					}
 				}
 			})
 
-			d.FieldArray("bcins", func(d *decode.D) {
-				for i := uint64(0); i < numbc; i++ {
-					d.FieldStruct("ins", func(d *decode.D) {
-						LuaJITDecodeBCIns(d)
-					})
+			if di.Opts.HeadersOnly {
+				d.FieldRawLen("body", d.BitsLeft())
+				return
+			}
+
+			LuaJITCheckCount(di, d, "numbc", numbc, 4)
+			LuaJITCheckCount(di, d, "numkgc", numkgc, 1)
+			LuaJITCheckCount(di, d, "numkn", numkn, 1)
+			LuaJITCheckCount(di, d, "debuglen", debuglen, 1)
+
+			// loops need the backward jumps ahead
+			var ins []Ins
+			if numbc*4 <= uint64(d.BitsLeft()/8) {
+				bs := d.PeekBytes(int(numbc * 4))
+				ins = make([]Ins, numbc)
+				for i := range ins {
+					if di.BigEndian {
+						ins[i] = insFromWord(binary.BigEndian.Uint32(bs[i*4:]))
+					} else {
+						ins[i] = insFromWord(binary.LittleEndian.Uint32(bs[i*4:]))
+					}
 				}
-			})
+			}
+			if len(ins) > 0 {
+				firstOp = ins[0].Op
+			}
+			var loops []Loop
+			if di.Opts.DecodeInstructions {
+				loops = Loops(ins, di.Opcodes)
+			}
+
+			di.ProtoKGC = nil
+			if di.Opts.DecodeInstructions {
+				di.ProtoKGC = peekKGC(di, d, numbc, numuv, numkgc)
+			}
+
+			if !di.Opts.DecodeInstructions {
+				d.FieldRawLen("bcins", int64(numbc)*4*8)
+			} else {
+				d.FieldArray("bcins", func(d *decode.D) {
+					for i := uint64(0); i < numbc; i++ {
+						di.Pos.Ins = int(i)
+						d.FieldStruct("ins", func(d *decode.D) {
+							LuaJITDecodeBCIns(di, d)
+							if depth, header := LoopAt(loops, int(i)); depth > 0 {
+								d.FieldValueUint("loop_depth", uint64(depth))
+								d.FieldValueUint("loop_header", uint64(header))
+							}
+						})
+					}
+					di.Pos.Ins = -1
+				})
+			}
+
+			// upvalue names are in the debug info at the end of the proto
+			var uvNames []string
+			if rest := d.PeekBytes(int(d.BitsLeft() / 8)); debuglen > 0 && debuglen <= uint64(len(rest)) {
+				dbg = &Proto{Ins: make([]Ins, numbc), UV: make([]uint16, numuv), NumLine: numline}
+				dbg.Debug = rest[len(rest)-int(debuglen):]
+				dbg.parseDebug(di.BigEndian)
+				uvNames = dbg.UVNames
+			}
 
 			d.FieldArray("uvdata", func(d *decode.D) {
 				for i := uint64(0); i < numuv; i++ {
-					d.FieldU16("uv")
+					if i < uint64(len(uvNames)) && uvNames[i] != "" {
+						d.FieldU16("uv", scalar.UintSym(uvNames[i]), uvDescription)
+					} else {
+						d.FieldU16("uv", uvDescription)
+					}
 				}
 			})
 
+			children := di.Summary.Constants["child"]
 			d.FieldArray("kgc", func(d *decode.D) {
 				for i := uint64(0); i < numkgc; i++ {
-					d.FieldStruct("kgc", LuaJITDecodeKGC)
+					di.Pos.KGC = int(i)
+					d.FieldStruct("kgc", func(d *decode.D) {
+						d.FieldValueUint("index", i)
+						// kgc is written in reverse, instruction D operands use the runtime index
+						d.FieldValueUint("runtime_index", numkgc-1-i)
+						LuaJITDecodeKGC(di, d)
+					})
 				}
+				di.Pos.KGC = -1
 			})
+			// the parser sets the child flag if a proto has child constants
+			switch hasChildren := di.Summary.Constants["child"] > children; {
+			case flags&protoFlagChild != 0 && !hasChildren:
+				LuaJITWarn(di, d, "child flag without child constants")
+			case flags&protoFlagChild == 0 && hasChildren:
+				LuaJITWarn(di, d, "child constants without child flag")
+			}
 
+			var knumBits []any
 			d.FieldArray("knum", func(d *decode.D) {
 				for i := uint64(0); i < numkn; i++ {
-					d.FieldAnyFn("knum", LuaJITDecodeKNum)
+					di.Pos.KNum = int(i)
+					di.Summary.constant("num")
+					var bits any
+					d.FieldAnyScalarFn("knum", func(d *decode.D) scalar.Any { return LuaJITDecodeKNum(di, d, &bits) })
+					knumBits = append(knumBits, bits)
+				}
+				di.Pos.KNum = -1
+			})
+			// same index as knum, null for ints
+			d.FieldArray("knum_bits", func(d *decode.D) {
+				for _, b := range knumBits {
+					d.FieldValueAny("knum_bits", b)
 				}
 			})
 
 			if !di.Strip {
 				d.LimitedFn(8*int64(debuglen), func(d *decode.D) {
-					LuaJITDecodeDebug(d, debuglen, numbc)
+					if di.Opts.DecodeDebug {
+						LuaJITDecodeDebug(d, debuglen, numbc, firstline, numline)
+					} else {
+						d.FieldRawLen("debug", d.BitsLeft())
+					}
 				})
 			}
 		})
+
+		// hidden data inside the declared length, LuaJIT fails to load the proto
+		// if pdata ends before it
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft(), scalar.BitBufDescription("after pdata, LuaJIT rejects proto"))
+		}
 	})
+
+	// protos are written children first so the main chunk is the last one before the end byte
+	d.FieldValueBool("main", d.BitsLeft() >= 8 && bytes.Equal(d.PeekBytes(1), []byte{0}))
+	if hasDebug {
+		d.FieldValueStr("name", fmt.Sprintf("%s:%d", di.ChunkName, firstline))
+	}
+
+	// ex "function(a, b, ...) example.lua:27-30", params are the first locals
+	// and are named p0, p1 etc if there is no debug info
+	var params []string
+	for i := 0; i < int(numparams); i++ {
+		name := fmt.Sprintf("p%d", i)
+		if dbg != nil && i < len(dbg.VarInfo) && dbg.VarInfo[i].StartPC == 0 && dbg.VarInfo[i].Name != "" {
+			name = dbg.VarInfo[i].Name
+		}
+		params = append(params, name)
+	}
+	if flags&protoFlagVararg != 0 {
+		params = append(params, "...")
+	}
+	signature := "function(" + strings.Join(params, ", ") + ")"
+	if hasDebug {
+		signature += fmt.Sprintf(" %s:%d-%d", di.ChunkName, firstline, firstline+numline)
+	}
+	d.FieldValueStr("signature", signature)
+
+	// from the function header LuaJIT adds when loading, a C function header
+	// can only be in the body of a crafted dump
+	switch di.Opcodes.Get(int(firstOp)).Name {
+	case "FUNCC", "FUNCCW":
+		d.FieldValueStr("kind", "c")
+	default:
+		if flags&protoFlagVararg != 0 {
+			d.FieldValueStr("kind", "vararg")
+		} else {
+			d.FieldValueStr("kind", "fixed")
+		}
+	}
 }
 
-func LuaJITDecode(d *decode.D) any {
-	di := DumpInfo{}
+// parse proto at current position without decoding it
+func LuaJITCheckProto(di *DumpInfo, index int, d *decode.D) error {
+	r := &dumpReader{buf: d.PeekBytes(int(d.BitsLeft() / 8)), be: di.BigEndian}
+	r.proto(di.dumpModel(), index)
+	return r.err
+}
 
-	d.FieldStruct("header", func(d *decode.D) {
-		LuaJITDecodeHeader(&di, d)
-	})
+// dump with the flags and opcodes of the dump being decoded for parsing protos
+// with dumpReader
+func (di *DumpInfo) dumpModel() *Dump {
+	var flags uint64
+	if di.BigEndian {
+		flags |= dumpFlagBE
+	}
+	if di.Strip {
+		flags |= dumpFlagStrip
+	}
+	return &Dump{Flags: flags, Opcodes: di.Opcodes}
+}
+
+// corrupt proto as raw data, declared length is used to continue with the next
+// proto if it fits otherwise the rest is raw data
+func LuaJITDecodeCorruptProto(index int, err error, d *decode.D) {
+	d.FieldValueUint("index", uint64(index))
+	d.FieldValueStr("error", err.Error())
+	r := &dumpReader{buf: d.PeekBytes(int(d.BitsLeft() / 8))}
+	length := r.uleb()
+	if r.err != nil || length > uint64(len(r.buf)-r.pos) {
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+	d.FieldULEB128("length")
+	d.FieldRawLen("data", int64(length)*8)
+}
+
+// proto option index or range with proto_last, all if proto is negative
+func (di *DumpInfo) protoSelected(index int) bool {
+	first := di.Opts.Proto
+	if first < 0 {
+		return true
+	}
+	last := di.Opts.ProtoLast
+	if last < first {
+		last = first
+	}
+	return int64(index) >= first && int64(index) <= last
+}
+
+// proto not selected by the proto option as raw data. It is parsed without the
+// decode tree to pop its child protos so that child constants of later protos
+// still resolve
+func LuaJITDecodeSkippedProto(di *DumpInfo, index int, d *decode.D) {
+	d.FieldValueUint("index", uint64(index))
+	peek := func(n int64) []byte {
+		if left := d.BitsLeft() / 8; n > left {
+			n = left
+		}
+		return d.PeekBytes(int(n))
+	}
+	r := &dumpReader{buf: peek(10)}
+	length := r.uleb()
+	r = &dumpReader{buf: peek(int64(r.pos) + int64(length)), be: di.BigEndian}
+	p := r.proto(di.dumpModel(), index)
+	if r.err != nil {
+		d.Fatalf("%s", r.err)
+	}
+	di.Summary.Protos++
+	di.Summary.Instructions += uint64(len(p.Ins))
+	for _, k := range p.KGC {
+		if n := len(di.ProtoStack); k.Type == kgcChild && n > 0 {
+			di.ProtoStack = di.ProtoStack[:n-1]
+		}
+	}
+
+	LuaJITFieldULEB128(di, d, "length")
+	d.FieldRawLen("pdata", int64(length)*8)
+}
+
+func LuaJITDecodeDump(di *DumpInfo, d *decode.D) {
+	start := d.Pos()
+	if di.Opts.NoHeader {
+		// bare protos, ex carved from memory, flags and opcodes from options
+		di.SetOpcodes()
+		di.SetFlags(di.Opts.NoHeaderFlags)
+	} else {
+		d.FieldStruct("header", func(d *decode.D) {
+			LuaJITDecodeHeader(di, d)
+		})
+	}
 
 	if di.BigEndian {
 		d.Endian = decode.BigEndian
@@ -388,20 +1409,164 @@ func LuaJITDecode(d *decode.D) any {
 	}
 
 	d.FieldArray("proto", func(d *decode.D) {
-		for {
+		for i := 0; ; i++ {
+			if (di.Opts.Recover || di.Opts.NoHeader) && d.BitsLeft() < 8 {
+				break
+			}
 			nextByte := d.PeekBytes(1)
 			if bytes.Equal(nextByte, []byte{0}) {
 				break
 			}
 
 			d.FieldStruct("proto", func(d *decode.D) {
-				LuaJITDecodeProto(&di, d)
+				if !di.protoSelected(i) {
+					di.withContext(i, func() { LuaJITDecodeSkippedProto(di, i, d) })
+					return
+				}
+				if di.Opts.Recover {
+					if err := LuaJITCheckProto(di, i, d); err != nil {
+						LuaJITDecodeCorruptProto(i, err, d)
+						return
+					}
+				}
+				di.withContext(i, func() { LuaJITDecodeProto(di, i, d) })
 			})
+			di.ProtoStack = append(di.ProtoStack, i)
 		}
 
 	})
 
-	d.FieldU8("end")
+	if !((di.Opts.Recover || di.Opts.NoHeader) && d.BitsLeft() < 8) {
+		d.FieldU8("end")
+	}
+
+	LuaJITDecodeSummary(di, d)
+	LuaJITDecodeWarnings(di, d)
+	if di.Opts.Variables {
+		LuaJITDecodeVariables(di, d, start)
+	}
+}
+
+func LuaJITDecodeSummary(di *DumpInfo, d *decode.D) {
+	d.FieldStruct("summary", func(d *decode.D) {
+		d.FieldValueUint("protos", di.Summary.Protos)
+		d.FieldValueUint("instructions", di.Summary.Instructions)
+		// constants are not decoded with headers_only
+		if !di.Opts.HeadersOnly {
+			d.FieldStruct("constants", func(d *decode.D) {
+				for _, kind := range kgcKinds {
+					d.FieldValueUint(kind, di.Summary.Constants[kind])
+				}
+				d.FieldValueUint("num", di.Summary.Constants["num"])
+			})
+			d.FieldValueUint("string_bytes", di.Summary.StringBytes)
+			d.FieldValueUint("integral_floats", di.Summary.IntegralFloats)
+			d.FieldValueUint("overlong_uleb", di.Summary.OverlongULEB)
+			LuaJITDecodeNumberModel(di, d)
+		}
+		d.FieldValueBool("stripped", di.Strip)
+		d.FieldValueBool("ffi", di.FFI)
+		d.FieldValueBool("big_endian", di.BigEndian)
+		d.FieldValueBool("fr2", di.FR2)
+	})
+}
+
+// dumps from DUALNUM and float builds differ only in that float builds
+// narrow all integral numbers to ints, so float can't be told apart from a
+// DUALNUM dump without integral floats
+func LuaJITDecodeNumberModel(di *DumpInfo, d *decode.D) {
+	switch {
+	case di.Opts.NumberModel != "auto":
+		d.FieldValueStr("number_model", di.Opts.NumberModel, scalar.StrDescription("from number_model option"))
+		if di.Opts.NumberModel == "float" && di.Summary.IntegralFloats > 0 {
+			LuaJITWarn(di, d, "%d integral float constants in float number model dump", di.Summary.IntegralFloats)
+		}
+	case di.Summary.IntegralFloats > 0:
+		d.FieldValueStr("number_model", "dualnum", scalar.StrDescription("has integral float constants"))
+	default:
+		d.FieldValueStr("number_model", "float", scalar.StrDescription("no integral float constants, can also be dualnum"))
+	}
+}
+
+// longest first line skipped
+const maxShebangLen = 4096
+
+// like luaL_loadfile a first line starting with "#" is skipped, ex a shebang,
+// and with max_prefix other bytes before the signature, ex a loader stub
+func LuaJITDecodePrefix(di *DumpInfo, d *decode.D) {
+	peek := func(n int64) []byte {
+		if left := d.BitsLeft() / 8; n > left {
+			n = left
+		}
+		return d.PeekBytes(int(n))
+	}
+
+	if bs := peek(maxShebangLen); len(bs) > 0 && bs[0] == '#' {
+		if i := bytes.IndexByte(bs, '\n'); i >= 0 {
+			d.FieldUTF8("shebang", i+1)
+		}
+	}
+	if di.Opts.MaxPrefix > 0 {
+		bs := peek(int64(di.Opts.MaxPrefix) + 3)
+		if i := bytes.Index(bs, []byte("\x1bLJ")); i > 0 {
+			d.FieldRawLen("prefix", int64(i)*8)
+		}
+	}
+}
+
+func LuaJITDecode(d *decode.D) any {
+	di := DumpInfo{}
+	d.ArgAs(&di.Opts)
+	if _, ok := dialectOpcodes[di.Opts.Dialect]; di.Opts.Dialect != "" && !ok {
+		d.Fatalf("unknown dialect %q", di.Opts.Dialect)
+	}
+	if _, ok := versionOptions[di.Opts.Version]; di.Opts.Version != 0 && !ok {
+		d.Fatalf("unknown version %v", di.Opts.Version)
+	}
+	switch di.Opts.WideInt {
+	case "decimal", "hex", "string":
+	default:
+		d.Fatalf("unknown wide_int %q", di.Opts.WideInt)
+	}
+	switch di.Opts.FloatFormat {
+	case "decimal", "hex", "bits":
+	default:
+		d.Fatalf("unknown float_format %q", di.Opts.FloatFormat)
+	}
+	switch di.Opts.NumberModel {
+	case "auto", "float", "dualnum":
+	default:
+		d.Fatalf("unknown number_model %q", di.Opts.NumberModel)
+	}
+
+	if !di.Opts.NoHeader {
+		LuaJITDecodePrefix(&di, d)
+	}
+	LuaJITDecodeDump(&di, d)
+
+	// several luajit -b outputs concatenated
+	isDump := func() bool {
+		return d.BitsLeft() >= 3*8 && bytes.Equal(d.PeekBytes(3), []byte{0x1b, 0x4c, 0x4a})
+	}
+	if isDump() {
+		d.FieldArray("dumps", func(d *decode.D) {
+			for isDump() {
+				d.FieldStruct("dump", func(d *decode.D) {
+					ndi := DumpInfo{Opts: di.Opts}
+					LuaJITDecodeDump(&ndi, d)
+				})
+			}
+		})
+	}
+
+	// data appended after the dump is ignored by LuaJIT
+	if d.BitsLeft() > 0 {
+		if di.Opts.ProbeTrailing {
+			d.FieldFormatOrRawLen("trailing", d.BitsLeft(), &probeGroup, format.Probe_In{})
+		} else {
+			d.FieldRawLen("trailing", d.BitsLeft())
+		}
+	}
 
 	return nil
 }
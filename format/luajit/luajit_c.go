@@ -0,0 +1,85 @@
+package luajit
+
+// luajit -b -t c and -t h output, see bcsave.lua savec
+//
+// -t c:
+// const unsigned char luaJIT_BC_<name>[] = {
+// 27,76,74,2,...
+// };
+//
+// -t h:
+// #define luaJIT_BC_<name>_SIZE <size>
+// static const unsigned char luaJIT_BC_<name>[] = {
+// 27,76,74,2,...
+// };
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+var luajitGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.LuaJIT_C,
+		&decode.Format{
+			Description: "LuaJIT bytecode as C array (luajit -b -t c/h)",
+			Groups:      []*decode.Group{format.Probe},
+			// before fuzzy text formats, luaJIT_BC_ array is unlikely to be something else
+			ProbeOrder: format.ProbeOrderTextJSON,
+			DecodeFn:   LuaJITCDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.LuaJIT}, Out: &luajitGroup},
+			},
+		})
+}
+
+var luajitCArrayRe = regexp.MustCompile(`luaJIT_BC_(\w+)\[\]\s*=\s*\{([^}]*)\}`)
+var luajitCSizeRe = regexp.MustCompile(`#define\s+luaJIT_BC_(\w+)_SIZE\s+(\d+)`)
+
+func LuaJITCDecode(d *decode.D) any {
+	text := string(d.BytesRange(0, int(d.BitsLeft()/8)))
+
+	m := luajitCArrayRe.FindStringSubmatchIndex(text)
+	if m == nil {
+		d.Fatalf("no luaJIT_BC_ array found")
+	}
+	name := text[m[2]:m[3]]
+
+	var bs []byte
+	for _, e := range strings.Split(text[m[4]:m[5]], ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(e, 0, 8)
+		if err != nil {
+			d.Fatalf("invalid array element %q", e)
+		}
+		bs = append(bs, byte(n))
+	}
+
+	d.FieldUTF8("declaration", m[4])
+	d.FieldValueStr("name", name)
+	if sm := luajitCSizeRe.FindStringSubmatch(text); sm != nil && sm[1] == name {
+		size, _ := strconv.ParseUint(sm[2], 10, 64)
+		d.FieldValueUint("size", size)
+		if size != uint64(len(bs)) {
+			d.Errorf("size %d does not match array length %d", size, len(bs))
+		}
+	}
+	d.FieldUTF8("array", m[5]-m[4])
+	d.FieldFormatBitBuf("dump", bitio.NewBitReader(bs, -1), &luajitGroup, nil)
+	if d.BitsLeft() > 0 {
+		d.FieldUTF8("trailing", int(d.BitsLeft()/8))
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package luajit
+
+// pair protos of two dumps by how similar their instructions are, unlike diff
+// and fingerprint protos can be reordered and constants or some instructions
+// changed
+
+import (
+	"math"
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+// pairs less similar than this are not matched
+const matchMinSimilarity = 0.5
+
+func init() {
+	interp.RegisterFunc1("luajit_match", func(_ *interp.Interp, c any, other any) any {
+		a, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		b, err := toDump(other)
+		if err != nil {
+			return err
+		}
+		return Match(a, b)
+	})
+}
+
+// opcode name bigrams of a proto, first instruction is paired with "" so a
+// proto with n instructions has n bigrams
+func opBigrams(dump *Dump, p *Proto) map[[2]string]int {
+	bigrams := map[[2]string]int{}
+	prev := ""
+	for pc := range p.Ins {
+		name := dump.OpName(p, pc)
+		bigrams[[2]string{prev, name}]++
+		prev = name
+	}
+	return bigrams
+}
+
+// Similarity is the dice coefficient of the opcode name bigrams of pa and pb,
+// 1 if the same sequence of opcodes, operands and constants are ignored
+func Similarity(a *Dump, pa *Proto, b *Dump, pb *Proto) float64 {
+	if len(pa.Ins)+len(pb.Ins) == 0 {
+		return 1
+	}
+	return dice(opBigrams(a, pa), opBigrams(b, pb), len(pa.Ins)+len(pb.Ins))
+}
+
+func dice(ba map[[2]string]int, bb map[[2]string]int, n int) float64 {
+	common := 0
+	for k, na := range ba {
+		if nb := bb[k]; nb < na {
+			common += nb
+		} else {
+			common += na
+		}
+	}
+	return float64(2*common) / float64(n)
+}
+
+// Match pairs protos greedily most similar first, ties by proto index. Protos
+// without a pair at least matchMinSimilarity similar are unmatched
+func Match(a *Dump, b *Dump) map[string]any {
+	type pair struct {
+		a, b       *Proto
+		similarity float64
+	}
+	var pairs []pair
+	bBigrams := map[*Proto]map[[2]string]int{}
+	for _, pb := range b.Protos {
+		bBigrams[pb] = opBigrams(b, pb)
+	}
+	for _, pa := range a.Protos {
+		aBigrams := opBigrams(a, pa)
+		for _, pb := range b.Protos {
+			// can't reach the minimum with too different lengths
+			na, nb := len(pa.Ins), len(pb.Ins)
+			if na > nb {
+				na, nb = nb, na
+			}
+			if float64(2*na) < matchMinSimilarity*float64(na+nb) {
+				continue
+			}
+			if na+nb == 0 {
+				pairs = append(pairs, pair{a: pa, b: pb, similarity: 1})
+			} else if s := dice(aBigrams, bBigrams[pb], na+nb); s >= matchMinSimilarity {
+				pairs = append(pairs, pair{a: pa, b: pb, similarity: s})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].similarity > pairs[j].similarity
+	})
+
+	matchedA := map[*Proto]bool{}
+	matchedB := map[*Proto]bool{}
+	matches := []any{}
+	for _, m := range pairs {
+		if matchedA[m.a] || matchedB[m.b] {
+			continue
+		}
+		matchedA[m.a] = true
+		matchedB[m.b] = true
+		matches = append(matches, map[string]any{
+			"a":          m.a.Index,
+			"a_name":     a.ProtoName(m.a),
+			"b":          m.b.Index,
+			"b_name":     b.ProtoName(m.b),
+			"similarity": math.Round(m.similarity*1000) / 1000,
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].(map[string]any)["a"].(int) < matches[j].(map[string]any)["a"].(int)
+	})
+
+	unmatched := func(dump *Dump, matched map[*Proto]bool) []any {
+		protos := []any{}
+		for _, p := range dump.Protos {
+			if !matched[p] {
+				protos = append(protos, map[string]any{"index": p.Index, "name": dump.ProtoName(p)})
+			}
+		}
+		return protos
+	}
+
+	return map[string]any{
+		"matches":     matches,
+		"unmatched_a": unmatched(a, matchedA),
+		"unmatched_b": unmatched(b, matchedB),
+	}
+}
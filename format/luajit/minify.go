@@ -0,0 +1,64 @@
+package luajit
+
+// smallest dump that loads the same, for shipping bytecode
+
+import (
+	"io"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type minifyOpts struct {
+	KeepDebug bool
+}
+
+func init() {
+	interp.RegisterFunc1("_luajit_minify", func(_ *interp.Interp, c any, opts minifyOpts) any {
+		br, err := interp.ToBitReader(c)
+		if err != nil {
+			return err
+		}
+		bits, err := br.SeekBits(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		dump.Minify(opts.KeepDebug)
+		buf := dump.Encode()
+		return map[string]any{
+			"dump":          toBinary(buf),
+			"size":          len(buf),
+			"original_size": int(bits / 8),
+			"saved":         int(bits/8) - len(buf),
+		}
+	})
+}
+
+// file name without directories, "@src/game/main.lua" is "@main.lua", custom
+// and source names are truncated to their first line
+func minifyChunkName(name string) string {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+			return "@" + name[i+1:]
+		}
+		return name
+	}
+	if i := strings.IndexByte(name, '\n'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// Minify strips debug info and chunk name, or if keepDebug only shortens the
+// chunk name, and normalizes the dump. Encode then writes ULEB128 with as few
+// bytes as possible and drops data before and after the dump
+func (dump *Dump) Minify(keepDebug bool) {
+	if keepDebug && !dump.Strip() {
+		dump.Name = minifyChunkName(dump.Name)
+	}
+	dump.Normalize(!keepDebug)
+}
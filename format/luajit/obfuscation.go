@@ -0,0 +1,121 @@
+package luajit
+
+// heuristics for signs of obfuscated or tampered dumps, none of them are proof
+// by themselves but several together usually are
+
+import (
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_obfuscation_report", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return ObfuscationReport(dump)
+	})
+}
+
+const (
+	// jumps per instruction, normal code is usually well below
+	obfuscationJumpDensity = 0.3
+	// fewer instructions than this are too few to say anything about density
+	obfuscationJumpMinIns = 10
+	// items in a table constant or constants in a proto
+	obfuscationHugeConstants = 1000
+)
+
+// ObfuscationReport scores signs of obfuscation for the dump and each proto,
+// score is the number of signs found
+func ObfuscationReport(dump *Dump) map[string]any {
+	signs := []any{}
+	if dump.Strip() {
+		signs = append(signs, "stripped")
+	} else {
+		// without @ or = prefix
+		name := dump.Name
+		if len(name) > 0 && (name[0] == '@' || name[0] == '=') {
+			name = name[1:]
+		}
+		if len(name) <= 1 {
+			signs = append(signs, "short_chunk_name")
+		}
+	}
+	if dump.OverlongULEB > 0 {
+		signs = append(signs, "overlong_uleb")
+	}
+	score := len(signs)
+
+	protos := []any{}
+	for _, p := range dump.Protos {
+		r := dump.protoObfuscationReport(p)
+		score += r["score"].(int)
+		protos = append(protos, r)
+	}
+
+	return map[string]any{
+		"score":  score,
+		"signs":  signs,
+		"protos": protos,
+	}
+}
+
+func (dump *Dump) protoObfuscationReport(p *Proto) map[string]any {
+	signs := []any{}
+
+	if p.OverlongULEB > 0 {
+		signs = append(signs, "overlong_uleb")
+	}
+
+	jumps := 0
+	for pc := range p.Ins {
+		if dump.Opcodes.Get(int(p.Ins[pc].Op)).IsJump() {
+			jumps++
+		}
+	}
+	var jumpDensity float64
+	if len(p.Ins) > 0 {
+		jumpDensity = float64(jumps) / float64(len(p.Ins))
+	}
+	if len(p.Ins) >= obfuscationJumpMinIns && jumpDensity > obfuscationJumpDensity {
+		signs = append(signs, "jump_density")
+	}
+
+	maxTableItems := 0
+	nestedDumps := 0
+	for _, k := range p.KGC {
+		switch k.Type {
+		case kgcTab:
+			if n := len(k.Tab.Array) + len(k.Tab.Hash); n > maxTableItems {
+				maxTableItems = n
+			}
+		case kgcStr:
+			if strings.Contains(k.Str, "\x1bLJ") {
+				nestedDumps++
+			}
+		}
+	}
+	if maxTableItems > obfuscationHugeConstants || len(p.KGC)+len(p.KNum) > obfuscationHugeConstants {
+		signs = append(signs, "huge_constants")
+	}
+	if nestedDumps > 0 {
+		signs = append(signs, "nested_dump")
+	}
+
+	return map[string]any{
+		"index":           p.Index,
+		"name":            dump.ProtoName(p),
+		"score":           len(signs),
+		"signs":           signs,
+		"overlong_uleb":   p.OverlongULEB,
+		"jumps":           jumps,
+		"instructions":    len(p.Ins),
+		"jump_density":    jumpDensity,
+		"constants":       len(p.KGC) + len(p.KNum),
+		"max_table_items": maxTableItems,
+		"nested_dumps":    nestedDumps,
+	}
+}
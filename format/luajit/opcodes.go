@@ -1,9 +1,63 @@
 package luajit
 
+//go:generate sh -c "go run gen/main.go opcodesLuaJIT20=lj_bc/v2.0.h opcodesLuaJIT21=lj_bc/v2.1.h | gofmt -s > opcodes_gen.go"
+
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+type bcOpts struct {
+	Version   float64
+	Dialect   string
+	BigEndian bool
+}
+
+func init() {
+	interp.RegisterFunc0("luajit_opcodes", func(_ *interp.Interp, _ any) any {
+		return OpcodesInfo()
+	})
+	interp.RegisterFunc1("_luajit_bc", func(_ *interp.Interp, c any, opts bcOpts) any {
+		version, ok := versionOptions[opts.Version]
+		opcodes := versionOpcodes[version]
+		if opts.Dialect != "" {
+			if opcodes, ok = dialectOpcodes[opts.Dialect]; !ok {
+				return fmt.Errorf("unknown dialect %q", opts.Dialect)
+			}
+		} else if !ok {
+			return fmt.Errorf("unknown version %v", opts.Version)
+		}
+
+		// number is the word as LuaJIT sees it, otherwise 4 bytes in dump order
+		if n, ok := toInt(c); ok {
+			if n < 0 || n > 0xffffffff {
+				return fmt.Errorf("word must be a number 0-0xffffffff")
+			}
+			return opcodes.DecodeWord(uint32(n))
+		}
+		br, err := interp.ToBitReader(c)
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(bitio.NewIOReader(br))
+		if err != nil {
+			return err
+		}
+		if len(buf) != 4 {
+			return fmt.Errorf("instruction must be 4 bytes, got %d", len(buf))
+		}
+		if opts.BigEndian {
+			return opcodes.DecodeWord(binary.BigEndian.Uint32(buf))
+		}
+		return opcodes.DecodeWord(binary.LittleEndian.Uint32(buf))
+	})
+}
+
 const (
 	BcMnone = iota
 	BcMdst
@@ -22,6 +76,25 @@ const (
 	BcMcdata
 )
 
+// mode names as in lj_bc.h
+var bcModeNames = []string{
+	BcMnone:  "none",
+	BcMdst:   "dst",
+	BcMbase:  "base",
+	BcMvar:   "var",
+	BcMrbase: "rbase",
+	BcMuv:    "uv",
+	BcMlit:   "lit",
+	BcMlits:  "lits",
+	BcMpri:   "pri",
+	BcMnum:   "num",
+	BcMstr:   "str",
+	BcMtab:   "tab",
+	BcMfunc:  "func",
+	BcMjump:  "jump",
+	BcMcdata: "cdata",
+}
+
 type BcDef struct {
 	Name string
 	MA   int
@@ -37,134 +110,172 @@ func (op *BcDef) IsJump() bool {
 	return op.MC == BcMjump
 }
 
+// signed literal, only used for D (KSHORT)
+func (op *BcDef) IsLits() bool {
+	return op.MC == BcMlits
+}
+
+// category by name, sections in lj_bc.h
+var bcCategories = map[string]string{}
+
+func init() {
+	for category, names := range map[string][]string{
+		"comparison": {"ISLT", "ISGE", "ISLE", "ISGT", "ISEQV", "ISNEV", "ISEQS", "ISNES", "ISEQN", "ISNEN", "ISEQP", "ISNEP",
+			"ISTC", "ISFC", "IST", "ISF", "ISTYPE", "ISNUM"},
+		"unary": {"MOV", "NOT", "UNM", "LEN"},
+		"arith": {"ADDVN", "SUBVN", "MULVN", "DIVVN", "MODVN", "ADDNV", "SUBNV", "MULNV", "DIVNV", "MODNV",
+			"ADDVV", "SUBVV", "MULVV", "DIVVV", "MODVV", "POW", "CAT"},
+		"constant": {"KSTR", "KCDATA", "KSHORT", "KNUM", "KPRI", "KNIL"},
+		"upvalue":  {"UGET", "USETV", "USETS", "USETN", "USETP", "UCLO", "FNEW"},
+		"table":    {"TNEW", "TDUP", "GGET", "GSET", "TGETV", "TGETS", "TGETB", "TGETR", "TSETV", "TSETS", "TSETB", "TSETM", "TSETR"},
+		"call":     {"CALLM", "CALL", "CALLMT", "CALLT", "ITERC", "ITERN", "VARG", "ISNEXT"},
+		"return":   {"RETM", "RET", "RET0", "RET1"},
+		"loop": {"FORI", "JFORI", "FORL", "IFORL", "JFORL", "ITERL", "IITERL", "JITERL",
+			"LOOP", "ILOOP", "JLOOP", "JMP"},
+		"function_header": {"FUNCF", "IFUNCF", "JFUNCF", "FUNCV", "IFUNCV", "JFUNCV", "FUNCC", "FUNCCW"},
+	} {
+		for _, n := range names {
+			bcCategories[n] = category
+		}
+	}
+}
+
+// comparison, unary, arith, constant, upvalue (also closures), table, call,
+// return, loop (also JMP) or function_header, empty for unknown opcodes
+func (op *BcDef) Category() string {
+	return bcCategories[op.Name]
+}
+
+// short descriptions from the comments in lj_bc.h
+var bcDescriptions = map[string]string{
+	"ISLT":  "jump if A < D",
+	"ISGE":  "jump if A >= D",
+	"ISLE":  "jump if A <= D",
+	"ISGT":  "jump if A > D",
+	"ISEQV": "jump if A == D",
+	"ISNEV": "jump if A ~= D",
+	"ISEQS": "jump if A == string D",
+	"ISNES": "jump if A ~= string D",
+	"ISEQN": "jump if A == number D",
+	"ISNEN": "jump if A ~= number D",
+	"ISEQP": "jump if A == primitive D",
+	"ISNEP": "jump if A ~= primitive D",
+
+	"ISTC":   "A = D and jump if D is true",
+	"ISFC":   "A = D and jump if D is false",
+	"IST":    "jump if D is true",
+	"ISF":    "jump if D is false",
+	"ISTYPE": "assert A is of type D",
+	"ISNUM":  "assert A is a number",
+
+	"MOV": "A = D",
+	"NOT": "A = not D",
+	"UNM": "A = -D",
+	"LEN": "A = #D",
+
+	"ADDVN": "A = B + number C",
+	"SUBVN": "A = B - number C",
+	"MULVN": "A = B * number C",
+	"DIVVN": "A = B / number C",
+	"MODVN": "A = B % number C",
+	"ADDNV": "A = number C + B",
+	"SUBNV": "A = number C - B",
+	"MULNV": "A = number C * B",
+	"DIVNV": "A = number C / B",
+	"MODNV": "A = number C % B",
+	"ADDVV": "A = B + C",
+	"SUBVV": "A = B - C",
+	"MULVV": "A = B * C",
+	"DIVVV": "A = B / C",
+	"MODVV": "A = B % C",
+	"POW":   "A = B ^ C",
+	"CAT":   "A = B .. ... .. C",
+
+	"KSTR":   "A = string D",
+	"KCDATA": "A = cdata D",
+	"KSHORT": "A = signed 16 bit D",
+	"KNUM":   "A = number D",
+	"KPRI":   "A = primitive D",
+	"KNIL":   "A, ..., D = nil",
+
+	"UGET":  "A = upvalue D",
+	"USETV": "upvalue A = D",
+	"USETS": "upvalue A = string D",
+	"USETN": "upvalue A = number D",
+	"USETP": "upvalue A = primitive D",
+	"UCLO":  "close upvalues for slots >= A and jump",
+	"FNEW":  "A = closure of proto D",
+
+	"TNEW":  "A = new table of size D",
+	"TDUP":  "A = copy of table D",
+	"GGET":  "A = _G[string D]",
+	"GSET":  "_G[string D] = A",
+	"TGETV": "A = B[C]",
+	"TGETS": "A = B[string C]",
+	"TGETB": "A = B[byte C]",
+	"TGETR": "A = B[C] without metamethods",
+	"TSETV": "B[C] = A",
+	"TSETS": "B[string C] = A",
+	"TSETB": "B[byte C] = A",
+	"TSETM": "(A-1)[D], (A-1)[D+1], ... = A, A+1, ...",
+	"TSETR": "B[C] = A without metamethods",
+
+	"CALLM":  "A, ..., A+B-2 = A(A+1, ..., A+C+MULTRES)",
+	"CALL":   "A, ..., A+B-2 = A(A+1, ..., A+C-1)",
+	"CALLMT": "return A(A+1, ..., A+D+MULTRES)",
+	"CALLT":  "return A(A+1, ..., A+D-1)",
+	"ITERC":  "A, A+1, A+2 = A-3, A-2, A-1; A, ..., A+B-2 = A(A+1, A+2)",
+	"ITERN":  "specialized ITERC for next",
+	"VARG":   "A, ..., A+B-2 = ...",
+	"ISNEXT": "verify ITERN specialization and jump",
+
+	"RETM": "return A, ..., A+D+MULTRES-1",
+	"RET":  "return A, ..., A+D-2",
+	"RET0": "return",
+	"RET1": "return A",
+
+	"FORI":   "numeric for loop init",
+	"JFORI":  "numeric for loop init, JIT compiled",
+	"FORL":   "numeric for loop",
+	"IFORL":  "numeric for loop, not JIT compiled",
+	"JFORL":  "numeric for loop, JIT compiled trace D",
+	"ITERL":  "iterator for loop",
+	"IITERL": "iterator for loop, not JIT compiled",
+	"JITERL": "iterator for loop, JIT compiled trace D",
+	"LOOP":   "generic loop",
+	"ILOOP":  "generic loop, not JIT compiled",
+	"JLOOP":  "generic loop, JIT compiled trace D",
+	"JMP":    "jump",
+
+	"FUNCF":  "fixed argument lua function",
+	"IFUNCF": "fixed argument lua function, not JIT compiled",
+	"JFUNCF": "fixed argument lua function, JIT compiled trace D",
+	"FUNCV":  "vararg lua function",
+	"IFUNCV": "vararg lua function, not JIT compiled",
+	"JFUNCV": "vararg lua function, JIT compiled trace D",
+	"FUNCC":  "C function",
+	"FUNCCW": "wrapped C function",
+}
+
+func (op *BcDef) Description() string {
+	return bcDescriptions[op.Name]
+}
+
 type BcDefList []BcDef
 
-var opcodes = BcDefList{
-	{"ISLT", BcMvar, BcMnone, BcMvar},
-	{"ISGE", BcMvar, BcMnone, BcMvar},
-	{"ISLE", BcMvar, BcMnone, BcMvar},
-	{"ISGT", BcMvar, BcMnone, BcMvar},
-
-	{"ISEQV", BcMvar, BcMnone, BcMvar},
-	{"ISNEV", BcMvar, BcMnone, BcMvar},
-	{"ISEQS", BcMvar, BcMnone, BcMstr},
-	{"ISNES", BcMvar, BcMnone, BcMstr},
-	{"ISEQN", BcMvar, BcMnone, BcMnum},
-	{"ISNEN", BcMvar, BcMnone, BcMnum},
-	{"ISEQP", BcMvar, BcMnone, BcMpri},
-	{"ISNEP", BcMvar, BcMnone, BcMpri},
-
-	// Unary test and copy ops.
-	{"ISTC", BcMdst, BcMnone, BcMvar},
-	{"ISFC", BcMdst, BcMnone, BcMvar},
-	{"IST", BcMnone, BcMnone, BcMvar},
-	{"ISF", BcMnone, BcMnone, BcMvar},
-	{"ISTYPE", BcMvar, BcMnone, BcMlit},
-	{"ISNUM", BcMvar, BcMnone, BcMlit},
-
-	// Unary ops.
-	{"MOV", BcMdst, BcMnone, BcMvar},
-	{"NOT", BcMdst, BcMnone, BcMvar},
-	{"UNM", BcMdst, BcMnone, BcMvar},
-	{"LEN", BcMdst, BcMnone, BcMvar},
-
-	// Binary ops. ORDER OPR. VV last, POW must be next.
-	{"ADDVN", BcMdst, BcMvar, BcMnum},
-	{"SUBVN", BcMdst, BcMvar, BcMnum},
-	{"MULVN", BcMdst, BcMvar, BcMnum},
-	{"DIVVN", BcMdst, BcMvar, BcMnum},
-	{"MODVN", BcMdst, BcMvar, BcMnum},
-
-	{"ADDNV", BcMdst, BcMvar, BcMnum},
-	{"SUBNV", BcMdst, BcMvar, BcMnum},
-	{"MULNV", BcMdst, BcMvar, BcMnum},
-	{"DIVNV", BcMdst, BcMvar, BcMnum},
-	{"MODNV", BcMdst, BcMvar, BcMnum},
-
-	{"ADDVV", BcMdst, BcMvar, BcMvar},
-	{"SUBVV", BcMdst, BcMvar, BcMvar},
-	{"MULVV", BcMdst, BcMvar, BcMvar},
-	{"DIVVV", BcMdst, BcMvar, BcMvar},
-	{"MODVV", BcMdst, BcMvar, BcMvar},
-
-	{"POW", BcMdst, BcMvar, BcMvar},
-	{"CAT", BcMdst, BcMrbase, BcMrbase},
-
-	// Constant ops.
-	{"KSTR", BcMdst, BcMnone, BcMstr},
-	{"KCDATA", BcMdst, BcMnone, BcMcdata},
-	{"KSHORT", BcMdst, BcMnone, BcMlits},
-	{"KNUM", BcMdst, BcMnone, BcMnum},
-	{"KPRI", BcMdst, BcMnone, BcMpri},
-	{"KNIL", BcMbase, BcMnone, BcMbase},
-
-	// Upvalue and function ops.
-	{"UGET", BcMdst, BcMnone, BcMuv},
-	{"USETV", BcMuv, BcMnone, BcMvar},
-	{"USETS", BcMuv, BcMnone, BcMstr},
-	{"USETN", BcMuv, BcMnone, BcMnum},
-	{"USETP", BcMuv, BcMnone, BcMpri},
-	{"UCLO", BcMrbase, BcMnone, BcMjump},
-	{"FNEW", BcMdst, BcMnone, BcMfunc},
-
-	// Table ops.
-	{"TNEW", BcMdst, BcMnone, BcMlit},
-	{"TDUP", BcMdst, BcMnone, BcMtab},
-	{"GGET", BcMdst, BcMnone, BcMstr},
-	{"GSET", BcMvar, BcMnone, BcMstr},
-	{"TGETV", BcMdst, BcMvar, BcMvar},
-	{"TGETS", BcMdst, BcMvar, BcMstr},
-	{"TGETB", BcMdst, BcMvar, BcMlit},
-	{"TGETR", BcMdst, BcMvar, BcMvar},
-	{"TSETV", BcMvar, BcMvar, BcMvar},
-	{"TSETS", BcMvar, BcMvar, BcMstr},
-	{"TSETB", BcMvar, BcMvar, BcMlit},
-	{"TSETM", BcMbase, BcMnone, BcMnum},
-	{"TSETR", BcMvar, BcMvar, BcMvar},
-
-	// Calls and vararg handling. T = tail call.
-	{"CALLM", BcMbase, BcMlit, BcMlit},
-	{"CALL", BcMbase, BcMlit, BcMlit},
-	{"CALLMT", BcMbase, BcMnone, BcMlit},
-	{"CALLT", BcMbase, BcMnone, BcMlit},
-	{"ITERC", BcMbase, BcMlit, BcMlit},
-	{"ITERN", BcMbase, BcMlit, BcMlit},
-	{"VARG", BcMbase, BcMlit, BcMlit},
-	{"ISNEXT", BcMbase, BcMnone, BcMjump},
-
-	// Returns.
-	{"RETM", BcMbase, BcMnone, BcMlit},
-	{"RET", BcMrbase, BcMnone, BcMlit},
-	{"RET0", BcMrbase, BcMnone, BcMlit},
-	{"RET1", BcMrbase, BcMnone, BcMlit},
-
-	// Loops and branches. I/J = interp/JIT, I/C/L = init/call/loop.
-	{"FORI", BcMbase, BcMnone, BcMjump},
-	{"JFORI", BcMbase, BcMnone, BcMjump},
-
-	{"FORL", BcMbase, BcMnone, BcMjump},
-	{"IFORL", BcMbase, BcMnone, BcMjump},
-	{"JFORL", BcMbase, BcMnone, BcMlit},
-
-	{"ITERL", BcMbase, BcMnone, BcMjump},
-	{"IITERL", BcMbase, BcMnone, BcMjump},
-	{"JITERL", BcMbase, BcMnone, BcMlit},
-
-	{"LOOP", BcMrbase, BcMnone, BcMjump},
-	{"ILOOP", BcMrbase, BcMnone, BcMjump},
-	{"JLOOP", BcMrbase, BcMnone, BcMlit},
-
-	{"JMP", BcMrbase, BcMnone, BcMjump},
-
-	// Function headers. I/J = interp/JIT, F/V/C = fixarg/vararg/C func.
-	{"FUNCF", BcMrbase, BcMnone, BcMnone},
-	{"IFUNCF", BcMrbase, BcMnone, BcMnone},
-	{"JFUNCF", BcMrbase, BcMnone, BcMlit},
-	{"FUNCV", BcMrbase, BcMnone, BcMnone},
-	{"IFUNCV", BcMrbase, BcMnone, BcMnone},
-	{"JFUNCV", BcMrbase, BcMnone, BcMlit},
-	{"FUNCC", BcMrbase, BcMnone, BcMnone},
-	{"FUNCCW", BcMrbase, BcMnone, BcMnone},
+// opcodesLuaJIT20 and opcodesLuaJIT21 are generated from the BCDEF macro in
+// lj_bc.h of each version, 2.0 does not have ISTYPE, ISNUM, TGETR and TSETR.
+// To add a version or fork add its lj_bc.h to lj_bc and a VAR=file argument
+// to go:generate
+//
+// see https://github.com/LuaJIT/LuaJIT/blob/v2.1/src/lj_bc.h
+
+// opcode definition, unknown opcodes have no name and a D operand
+func (opcodes BcDefList) Get(op int) *BcDef {
+	if op < len(opcodes) {
+		return &opcodes[op]
+	}
+	return &BcDef{}
 }
 
 func (opcodes BcDefList) MapUint(s scalar.Uint) (scalar.Uint, error) {
@@ -172,7 +283,76 @@ func (opcodes BcDefList) MapUint(s scalar.Uint) (scalar.Uint, error) {
 
 	if listIdx < len(opcodes) {
 		s.Sym = opcodes[listIdx].Name
+		s.Description = opcodes[listIdx].Description()
 	}
 
 	return s, nil
 }
+
+// OpcodesInfo is all known opcodes in 2.1 order with operand modes and opcode
+// number per version, versions that do not have the opcode are left out
+func OpcodesInfo() []any {
+	versions := []uint64{versionLuaJIT20, versionLuaJIT21}
+
+	var ops []any
+	for _, def := range opcodesLuaJIT21 {
+		opcodes := map[string]any{}
+		var vs []any
+		for _, v := range versions {
+			for i, vdef := range versionOpcodes[v] {
+				if vdef.Name == def.Name {
+					opcodes[versionMap[v].Sym.(string)] = i
+					vs = append(vs, versionMap[v].Sym)
+				}
+			}
+		}
+
+		op := map[string]any{
+			"name":        def.Name,
+			"category":    def.Category(),
+			"description": def.Description(),
+			"a":           bcModeNames[def.MA],
+			"opcodes":     opcodes,
+			"versions":    vs,
+		}
+		if def.HasD() {
+			op["format"] = "ad"
+			op["d"] = bcModeNames[def.MC]
+		} else {
+			op["format"] = "abc"
+			op["b"] = bcModeNames[def.MB]
+			op["c"] = bcModeNames[def.MC]
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// DecodeWord decodes an instruction word op | a<<8 | c<<16 | b<<24 or
+// op | a<<8 | d<<16, jumps have a signed j and lits a signed d
+func (opcodes BcDefList) DecodeWord(w uint32) map[string]any {
+	op := int(w & 0xff)
+	def := opcodes.Get(op)
+	ins := map[string]any{
+		"word": int(w),
+		"op":   op,
+		"a":    int(w >> 8 & 0xff),
+	}
+	if def.Name != "" {
+		ins["name"] = def.Name
+		ins["category"] = def.Category()
+	}
+	d := w >> 16
+	switch {
+	case def.IsJump():
+		ins["j"] = int(d) - 0x8000
+	case def.IsLits():
+		ins["d"] = int(int16(d))
+	case def.HasD():
+		ins["d"] = int(d)
+	default:
+		ins["b"] = int(d >> 8)
+		ins["c"] = int(d & 0xff)
+	}
+	return ins
+}
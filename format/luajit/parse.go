@@ -0,0 +1,69 @@
+package luajit
+
+// parsing for use as a go package without fq, the dump model in dump.go is the
+// same one the jq functions use
+
+import (
+	"errors"
+	"io"
+)
+
+// KGC.Type
+const (
+	KGCChild   = kgcChild
+	KGCTab     = kgcTab
+	KGCI64     = kgcI64
+	KGCU64     = kgcU64
+	KGCComplex = kgcComplex
+	KGCStr     = kgcStr
+)
+
+// KTabK.Type
+const (
+	KTabNil   = ktabNil
+	KTabFalse = ktabFalse
+	KTabTrue  = ktabTrue
+	KTabInt   = ktabInt
+	KTabNum   = ktabNum
+	KTabStr   = ktabStr
+)
+
+// Parse reads the first dump from r, a first line starting with # is skipped.
+// Offsets are from the signature and the opcodes are the ones of the dump
+// version
+func Parse(r io.Reader) (*Dump, error) {
+	pr := NewProtoReader(r, nil)
+	pr.first = true
+	dumps, err := readDumps(pr)
+	if err != nil {
+		return nil, err
+	} else if len(dumps) == 0 {
+		return nil, errors.New("dump has no protos")
+	}
+	return dumps[0], nil
+}
+
+// ParseAll reads all concatenated dumps from r like Parse, up to the first
+// byte after a dump that does not start another one. opcodes is used for all
+// dumps instead of the ones based on version if non-nil
+func ParseAll(r io.Reader, opcodes BcDefList) ([]*Dump, error) {
+	return readDumps(NewProtoReader(r, opcodes))
+}
+
+func readDumps(pr *ProtoReader) ([]*Dump, error) {
+	var dumps []*Dump
+	for {
+		dump, p, err := pr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(dumps) == 0 || dumps[len(dumps)-1] != dump {
+			dump.Offset = pr.DumpOffset()
+			dumps = append(dumps, dump)
+		}
+		dump.Protos = append(dump.Protos, p)
+	}
+	return dumps, nil
+}
@@ -0,0 +1,222 @@
+package luajit_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/wader/fq/format/luajit"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+// dumps in testdata that decode without errors, long_name.luac has a two byte
+// name length and wide_num.luac number constants with bits above 32 in the low
+// half that LuaJIT drops
+var parseTestFiles = []string{
+	"deobfuscate.luac",
+	"dualnum.luac",
+	"kshort.luac",
+	"ktab_int.luac",
+	"lineinfo_wide.luac",
+	"long_name.luac",
+	"loops.luac",
+	"multi.luac",
+	"negative.luac",
+	"negative_be.luac",
+	"shebang.luac",
+	"simple.luac",
+	"simple_stripped.luac",
+	"special_num.luac",
+	"wide_int.luac",
+	"wide_num.luac",
+}
+
+// child of a struct by name or an array by index
+func field(v *decode.Value, path ...any) *decode.Value {
+	for _, p := range path {
+		if v == nil {
+			return nil
+		}
+		c, ok := v.V.(*decode.Compound)
+		if !ok {
+			return nil
+		}
+		switch p := p.(type) {
+		case string:
+			v = c.ByName[p]
+		case int:
+			if p >= len(c.Children) {
+				return nil
+			}
+			v = c.Children[p]
+		}
+	}
+	return v
+}
+
+func actual(v *decode.Value) any {
+	if s, ok := v.V.(interface{ ScalarActual() any }); ok {
+		return s.ScalarActual()
+	}
+	return nil
+}
+
+func length(v *decode.Value) int {
+	if c, ok := v.V.(*decode.Compound); ok {
+		return len(c.Children)
+	}
+	return -1
+}
+
+func numBits(f float64) string {
+	return fmt.Sprintf("0x%016x", math.Float64bits(f))
+}
+
+func decodeLuaJIT(t *testing.T, buf []byte) *decode.Value {
+	t.Helper()
+	v, _, err := decode.Decode(context.Background(), bitio.NewBitReader(buf, -1), interp.DefaultRegistry.MustGroup("luajit"), decode.Options{IsRoot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// fields of the decode tree are the same as in the dump model
+func compareDump(t *testing.T, dv *decode.Value, dump *luajit.Dump) {
+	t.Helper()
+	check := func(what string, got any, expected any) {
+		t.Helper()
+		if got != expected {
+			t.Errorf("%s: decoder %v, Parse %v", what, got, expected)
+		}
+	}
+
+	check("version", actual(field(dv, "header", "version")), dump.Version)
+	if !dump.Strip() {
+		check("name", actual(field(dv, "header", "name")), dump.Name)
+	}
+	protos := field(dv, "proto")
+	check("protos", length(protos), len(dump.Protos))
+	for i, p := range dump.Protos {
+		pd := field(protos, i, "pdata")
+		at := func(what string) string { return fmt.Sprintf("proto %d %s", i, what) }
+
+		check(at("flags"), actual(field(pd, "phead", "flags")), uint64(p.Flags))
+		check(at("numparams"), actual(field(pd, "phead", "numparams")), uint64(p.NumParams))
+		check(at("framesize"), actual(field(pd, "phead", "framesize")), uint64(p.FrameSize))
+
+		check(at("instructions"), length(field(pd, "bcins")), len(p.Ins))
+		for pc, ins := range p.Ins {
+			iv := field(pd, "bcins", pc)
+			check(at(fmt.Sprintf("pc %d op", pc)), actual(field(iv, "op")), uint64(ins.Op))
+			check(at(fmt.Sprintf("pc %d a", pc)), actual(field(iv, "a")), uint64(ins.A))
+			if b := field(iv, "b"); b != nil {
+				check(at(fmt.Sprintf("pc %d b", pc)), actual(b), uint64(ins.B))
+				check(at(fmt.Sprintf("pc %d c", pc)), actual(field(iv, "c")), uint64(ins.C))
+			}
+		}
+
+		check(at("uv"), length(field(pd, "uvdata")), len(p.UV))
+		for j, uv := range p.UV {
+			check(at(fmt.Sprintf("uv %d", j)), actual(field(pd, "uvdata", j)), uint64(uv))
+		}
+
+		check(at("kgc"), length(field(pd, "kgc")), len(p.KGC))
+		for j, k := range p.KGC {
+			kv := field(pd, "kgc", j)
+			typ := k.Type
+			if k.Type == luajit.KGCStr {
+				// string length is in the type
+				typ += uint64(len(k.Str))
+			}
+			check(at(fmt.Sprintf("kgc %d type", j)), actual(field(kv, "type")), typ)
+			switch {
+			case k.Type == luajit.KGCStr:
+				check(at(fmt.Sprintf("kgc %d value", j)), actual(field(kv, "value")), k.Str)
+			case k.Type == luajit.KGCTab:
+				for a, tk := range k.Tab.Array {
+					compareKTabK(t, at(fmt.Sprintf("kgc %d array %d", j, a)), field(kv, "array", a), tk)
+				}
+				for h, pair := range k.Tab.Hash {
+					compareKTabK(t, at(fmt.Sprintf("kgc %d hash %d key", j, h)), field(kv, "hash", h, "key"), pair[0])
+					compareKTabK(t, at(fmt.Sprintf("kgc %d hash %d value", j, h)), field(kv, "hash", h, "value"), pair[1])
+				}
+			}
+		}
+
+		check(at("knum"), length(field(pd, "knum")), len(p.KNum))
+		for j, n := range p.KNum {
+			if n.IsInt {
+				check(at(fmt.Sprintf("knum %d", j)), actual(field(pd, "knum", j)), int64(n.Int))
+			} else {
+				check(at(fmt.Sprintf("knum %d", j)), actual(field(pd, "knum_bits", j)), numBits(n.Num))
+			}
+		}
+	}
+}
+
+func compareKTabK(t *testing.T, what string, v *decode.Value, k luajit.KTabK) {
+	t.Helper()
+	var got, expected any
+	switch k.Type {
+	case luajit.KTabInt:
+		got, expected = actual(field(v, "value")), int64(k.Int)
+	case luajit.KTabNum:
+		got, expected = actual(field(v, "value_bits")), numBits(k.Num)
+	case luajit.KTabStr:
+		got, expected = actual(field(v, "value")), k.Str
+	default:
+		return
+	}
+	if got != expected {
+		t.Errorf("%s: decoder %v, Parse %v", what, got, expected)
+	}
+}
+
+func TestParseMatchesDecoder(t *testing.T) {
+	for _, name := range parseTestFiles {
+		t.Run(name, func(t *testing.T) {
+			buf, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dv := decodeLuaJIT(t, buf)
+
+			dumps, err := luajit.ParseAll(bytes.NewReader(buf), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareDump(t, dv, dumps[0])
+			for i, dump := range dumps[1:] {
+				compareDump(t, field(dv, "dumps", i), dump)
+			}
+
+			dump, err := luajit.Parse(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareDump(t, dv, dump)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input []byte
+		err   string
+	}{
+		{[]byte("abc"), "not a LuaJIT bytecode dump"},
+		{[]byte("\x1bLJ\x02\x02\xff\xff\xff\xff\x0f"), "unexpected end of dump"},
+		{[]byte("\x1bLJ\x02\x02\x00"), "dump has no protos"},
+	} {
+		_, err := luajit.Parse(bytes.NewReader(tc.input))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: expected error %q, got %v", tc.input, tc.err, err)
+		}
+	}
+}
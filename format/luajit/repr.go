@@ -0,0 +1,165 @@
+package luajit
+
+// semantic representation used by torepr, functions as a tree starting at the
+// main chunk with constants as values and instructions as text
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("_luajit_repr", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Repr(dump)
+	})
+}
+
+func (k KTabK) value() any {
+	switch k.Type {
+	case ktabNil:
+		return nil
+	case ktabFalse:
+		return false
+	case ktabTrue:
+		return true
+	case ktabInt:
+		return int64(k.Int)
+	case ktabNum:
+		return k.Num
+	default:
+		return k.Str
+	}
+}
+
+// same rules as table_value, array if a sequence otherwise object
+func (t *KTab) value() any {
+	if len(t.Hash) == 0 && (len(t.Array) == 0 || t.Array[0].Type == ktabNil) {
+		vs := []any{}
+		for i := 1; i < len(t.Array); i++ {
+			vs = append(vs, luaJQValue(t.Array[i].value()))
+		}
+		return vs
+	}
+
+	obj := map[string]any{}
+	field := func(k string, v any) {
+		if _, ok := obj[k]; ok || v == nil {
+			return
+		}
+		obj[k] = luaJQValue(v)
+	}
+	for i, v := range t.Array {
+		field(luaKeyString(int64(i)), v.value())
+	}
+	for _, kv := range t.Hash {
+		field(luaKeyString(kv[0].value()), kv[1].value())
+	}
+	return obj
+}
+
+// u64 that does not fit a jq number is a big int
+func u64JQValue(u uint64) any {
+	if u > 1<<53 {
+		return new(big.Int).SetUint64(u)
+	}
+	return int(u)
+}
+
+func (dump *Dump) kgcRepr(k KGC) any {
+	switch k.Type {
+	case kgcChild:
+		if k.Child == nil {
+			return nil
+		}
+		return dump.ProtoName(k.Child)
+	case kgcTab:
+		return k.Tab.value()
+	case kgcI64:
+		if k.I64 > 1<<53 || k.I64 < -(1<<53) {
+			return big.NewInt(k.I64)
+		}
+		return int(k.I64)
+	case kgcU64:
+		return u64JQValue(k.U64)
+	case kgcComplex:
+		return complexString(k.Real, k.Imag)
+	default:
+		return k.Str
+	}
+}
+
+func (dump *Dump) protoRepr(p *Proto) map[string]any {
+	uvs := []any{}
+	for i, uv := range p.UV {
+		if n := p.uvName(i); n != "" {
+			uvs = append(uvs, n)
+		} else {
+			uvs = append(uvs, int(uv))
+		}
+	}
+
+	// in operand order
+	kgcs := []any{}
+	for i := len(p.KGC) - 1; i >= 0; i-- {
+		kgcs = append(kgcs, dump.kgcRepr(p.KGC[i]))
+	}
+
+	knums := []any{}
+	for _, k := range p.KNum {
+		if k.IsInt {
+			knums = append(knums, int(k.Int))
+		} else {
+			knums = append(knums, k.Num)
+		}
+	}
+
+	ins := []any{}
+	for pc := range p.Ins {
+		// without pc and padding
+		ins = append(ins, strings.Join(strings.Fields(dump.InsString(p, pc))[1:], " "))
+	}
+
+	children := []any{}
+	for _, c := range p.Children() {
+		children = append(children, dump.protoRepr(c))
+	}
+
+	fn := map[string]any{
+		"name":         dump.ProtoName(p),
+		"index":        p.Index,
+		"params":       int(p.NumParams),
+		"vararg":       p.Vararg(),
+		"framesize":    int(p.FrameSize),
+		"upvalues":     uvs,
+		"constants":    kgcs,
+		"numbers":      knums,
+		"instructions": ins,
+		"functions":    children,
+	}
+	if !dump.Strip() {
+		fn["line"] = int(p.FirstLine)
+	}
+	return fn
+}
+
+// Repr is the dump as version, chunk name and main function with its child
+// functions nested
+func Repr(dump *Dump) map[string]any {
+	r := map[string]any{
+		"version":  int(dump.Version),
+		"stripped": dump.Strip(),
+	}
+	if !dump.Strip() {
+		r["name"] = dump.Name
+	}
+	if main := dump.Main(); main != nil {
+		r["main"] = dump.protoRepr(main)
+	}
+	return r
+}
@@ -0,0 +1,88 @@
+package luajit
+
+// modules loaded by require("name") calls
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_requires", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Requires(dump)
+	})
+}
+
+// Requires finds calls of require, from a global or an upvalue named require
+// (needs debug info), with a string constant argument. Slots are followed
+// linearly like SuspiciousAPI, module is null if the argument is not a constant
+func Requires(dump *Dump) []any {
+	found := []any{}
+	for _, p := range dump.Protos {
+		// slots holding require
+		requires := map[int]bool{}
+		// slot to string constant
+		strs := map[int]string{}
+
+		for pc, ins := range p.Ins {
+			a := int(ins.A)
+			switch dump.OpName(p, pc) {
+			case "GGET":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr && k.Str == "require" {
+					requires[a] = true
+					delete(strs, a)
+					continue
+				}
+			case "UGET":
+				if int(ins.D) < len(p.UVNames) && p.UVNames[ins.D] == "require" {
+					requires[a] = true
+					delete(strs, a)
+					continue
+				}
+			case "MOV":
+				if requires[int(ins.D)] {
+					requires[a] = true
+					continue
+				}
+				if s, ok := strs[int(ins.D)]; ok {
+					strs[a] = s
+					continue
+				}
+			case "KSTR":
+				if k := p.KGCByD(int(ins.D)); k != nil && k.Type == kgcStr {
+					strs[a] = k.Str
+					delete(requires, a)
+					continue
+				}
+			case "CALL", "CALLT":
+				if requires[a] {
+					arg := a + 1
+					if dump.FR2() {
+						arg++
+					}
+					r := map[string]any{
+						"proto":      p.Index,
+						"proto_name": dump.ProtoName(p),
+						"pc":         pc,
+						"module":     nil,
+					}
+					if s, ok := strs[arg]; ok {
+						r["module"] = s
+					}
+					if len(p.LineInfo) == len(p.Ins) {
+						r["line"] = int(p.Line(pc))
+					}
+					found = append(found, r)
+				}
+			}
+			if def := dump.Opcodes.Get(int(ins.Op)); def.MA == BcMdst || def.MA == BcMbase {
+				delete(requires, a)
+				delete(strs, a)
+			}
+		}
+	}
+	return found
+}
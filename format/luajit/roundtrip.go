@@ -0,0 +1,150 @@
+package luajit
+
+// check that parsing and encoding a dump gives the same bytes, so that
+// transforms that encode again only change what they are meant to
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_roundtrip_check", func(_ *interp.Interp, c any) any {
+		br, err := interp.ToBitReader(c)
+		if err != nil {
+			return err
+		}
+		buf, err := io.ReadAll(bitio.NewIOReader(br))
+		if err != nil {
+			return err
+		}
+		offset := 0
+		if i := bytes.Index(buf, []byte("\x1bLJ")); i > 0 {
+			buf = buf[i:]
+			offset = i
+		}
+		dump, err := ParseDump(buf, nil)
+		if err != nil {
+			return err
+		}
+		return RoundtripCheck(dump, buf, offset)
+	})
+}
+
+// first differing byte of orig and enc and the marked field it is in, enc
+// ending early is in its last field
+func firstDiff(orig, enc []byte, marks []writeMark) (pos int, field writeMark, ok bool) {
+	for pos < len(orig) && pos < len(enc) && orig[pos] == enc[pos] {
+		pos++
+	}
+	if pos == len(orig) && pos == len(enc) {
+		return 0, writeMark{}, true
+	}
+	for _, m := range marks {
+		if m.pos > pos {
+			break
+		}
+		field = m
+	}
+	return pos, field, false
+}
+
+// a difference in a segment of the dump starting at offset
+type roundtripDiff struct {
+	offset    int
+	orig, enc []byte
+	pos       int
+	field     writeMark
+}
+
+// first difference comparing the header, each proto body and then its length
+// and the end byte, so that a changed body is not only a changed length
+func roundtripFirstDiff(dump *Dump, buf []byte) (roundtripDiff, bool) {
+	segment := func(offset int, orig []byte, w *dumpWriter) (roundtripDiff, bool) {
+		pos, field, ok := firstDiff(orig, w.buf, w.marks)
+		return roundtripDiff{offset: offset, orig: orig, enc: w.buf, pos: pos, field: field}, !ok
+	}
+
+	w := &dumpWriter{be: dump.BigEndian(), marking: true}
+	dump.encodeHeader(w)
+	end := len(buf)
+	if len(dump.Protos) > 0 {
+		end = dump.Protos[0].Offset
+	}
+	if d, ok := segment(0, buf[:end], w); ok {
+		return d, true
+	}
+	for _, p := range dump.Protos {
+		r := &dumpReader{buf: buf, pos: p.Offset}
+		n := int(r.uleb())
+		body, marks := dump.encodeProto(p, true)
+		if d, ok := segment(r.pos, buf[r.pos:r.pos+n], &dumpWriter{buf: body, marks: marks}); ok {
+			return d, true
+		}
+		w := &dumpWriter{marking: true}
+		w.mark(".proto[%d].length", p.Index)
+		w.uleb(uint64(len(body)))
+		if d, ok := segment(p.Offset, buf[p.Offset:r.pos], w); ok {
+			return d, true
+		}
+		end = r.pos + n
+	}
+	w = &dumpWriter{marking: true}
+	w.mark(".end")
+	w.u8(0)
+	if d, ok := segment(end, buf[end:end+1], w); ok {
+		return d, true
+	}
+	if end+1 < len(buf) {
+		return roundtripDiff{offset: end + 1, orig: buf[end+1:], field: writeMark{field: "trailing data"}}, true
+	}
+	return roundtripDiff{}, false
+}
+
+// RoundtripCheck encodes dump and compares it with buf, the bytes it was
+// parsed from. The first difference is reported with its offset in the input,
+// base is the offset of buf in it, the field it is in as a path like in the
+// decode tree and bytes from the start of the field. A difference in a proto
+// is reported instead of the difference in its length it causes, bytes after
+// the dump are a difference
+func RoundtripCheck(dump *Dump, buf []byte, base int) map[string]any {
+	enc := dump.Encode()
+	r := map[string]any{
+		"ok":           bytes.Equal(enc, buf),
+		"size":         len(buf),
+		"encoded_size": len(enc),
+	}
+	d, ok := roundtripFirstDiff(dump, buf)
+	if !ok {
+		return r
+	}
+
+	context := func(bs []byte) string {
+		end := d.pos + 8
+		if end > len(bs) {
+			end = len(bs)
+		}
+		if d.field.pos > end {
+			return ""
+		}
+		return fmt.Sprintf("% x", bs[d.field.pos:end])
+	}
+	r["offset"] = base + d.offset + d.pos
+	r["field"] = d.field.field
+	r["field_offset"] = base + d.offset + d.field.pos
+	r["original"] = context(d.orig)
+	r["encoded"] = context(d.enc)
+
+	overlong := dump.OverlongULEB
+	for _, p := range dump.Protos {
+		overlong += p.OverlongULEB
+	}
+	if overlong > 0 {
+		r["hint"] = fmt.Sprintf("%d overlong ULEB128, encoded with as few bytes as possible", overlong)
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+package luajit
+
+// nested block scopes of locals from the debug info variable ranges
+
+import (
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_scopes", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Scopes(dump)
+	})
+}
+
+// Scope is a block with the variables declared in it, pcs are as in the debug
+// info, see Variable
+type Scope struct {
+	StartPC   uint64
+	EndPC     uint64
+	Variables []Variable
+	Scopes    []*Scope
+}
+
+func (s *Scope) value() map[string]any {
+	vars := []any{}
+	for _, v := range s.Variables {
+		vars = append(vars, map[string]any{
+			"name":     v.Name,
+			"slot":     v.Slot,
+			"start_pc": v.StartPC,
+			"end_pc":   v.EndPC,
+		})
+	}
+	scopes := []any{}
+	for _, c := range s.Scopes {
+		scopes = append(scopes, c.value())
+	}
+	return map[string]any{
+		"start_pc":  s.StartPC,
+		"end_pc":    s.EndPC,
+		"variables": vars,
+		"scopes":    scopes,
+	}
+}
+
+// ProtoScopes is the function scope of p with the blocks in it. LuaJIT ends
+// all variables of a block at the same pc so a block is the variables with
+// the same end pc, starting where the first of them starts, and blocks are
+// nested by their ranges. Nested blocks ending at the same pc are one block
+func ProtoScopes(p *Proto) *Scope {
+	params, locals, _ := ProtoVariables(p)
+	root := &Scope{StartPC: 0, EndPC: uint64(len(p.Ins)) + 1}
+	root.Variables = append(root.Variables, params...)
+
+	byEnd := map[uint64]*Scope{}
+	var blocks []*Scope
+	for _, v := range locals {
+		if v.EndPC >= root.EndPC {
+			root.Variables = append(root.Variables, v)
+			continue
+		}
+		b, ok := byEnd[v.EndPC]
+		if !ok {
+			b = &Scope{StartPC: v.StartPC, EndPC: v.EndPC}
+			byEnd[v.EndPC] = b
+			blocks = append(blocks, b)
+		}
+		if v.StartPC < b.StartPC {
+			b.StartPC = v.StartPC
+		}
+		b.Variables = append(b.Variables, v)
+	}
+
+	// outer blocks first, then nest in the innermost block containing it
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].StartPC != blocks[j].StartPC {
+			return blocks[i].StartPC < blocks[j].StartPC
+		}
+		return blocks[i].EndPC > blocks[j].EndPC
+	})
+	stack := []*Scope{root}
+	for _, b := range blocks {
+		for len(stack) > 1 {
+			top := stack[len(stack)-1]
+			if top.StartPC <= b.StartPC && b.EndPC <= top.EndPC {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.Scopes = append(parent.Scopes, b)
+		stack = append(stack, b)
+	}
+	return root
+}
+
+// Scopes is the scope tree of each proto, only the function scope without
+// debug info
+func Scopes(dump *Dump) []any {
+	protos := []any{}
+	for _, p := range dump.Protos {
+		protos = append(protos, map[string]any{
+			"index": p.Index,
+			"name":  dump.ProtoName(p),
+			"scope": ProtoScopes(p).value(),
+		})
+	}
+	return protos
+}
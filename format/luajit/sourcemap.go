@@ -0,0 +1,49 @@
+package luajit
+
+// instruction index to source line per proto
+
+import (
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_sourcemap", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return SourceMap(dump)
+	})
+}
+
+// file name from a "@" chunk name, nil for custom names and source
+func (dump *Dump) file() any {
+	if dump.Strip() || !strings.HasPrefix(dump.Name, "@") {
+		return nil
+	}
+	return dump.Name[1:]
+}
+
+// SourceMap is per proto the line for each instruction index, lines are empty
+// if there is no debug info
+func SourceMap(dump *Dump) []any {
+	file := dump.file()
+	protos := []any{}
+	for _, p := range dump.Protos {
+		lines := []any{}
+		if len(p.LineInfo) == len(p.Ins) {
+			for pc := range p.Ins {
+				lines = append(lines, int(p.Line(pc)))
+			}
+		}
+		protos = append(protos, map[string]any{
+			"proto": p.Index,
+			"name":  dump.ProtoName(p),
+			"file":  file,
+			"lines": lines,
+		})
+	}
+	return protos
+}
@@ -0,0 +1,61 @@
+package luajit
+
+// guess what a string constant is used for
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	strClassIdentRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	strClassURLRe    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://[^\s]+$`)
+	strClassPathRe   = regexp.MustCompile(`^[A-Za-z0-9_.~/\\:@+-]+$`)
+	strClassFormatRe = regexp.MustCompile(`%[-+ #0]*[0-9]*(\.[0-9]+)?[sdiouxXeEfgGqcaA]`)
+	strClassBase64Re = regexp.MustCompile(`^[A-Za-z0-9+/]+={0,2}$`)
+)
+
+// min length for base64, shorter ones are usually words
+const strClassBase64Min = 16
+
+// StrClass is empty, binary (not UTF-8 or has control characters), url,
+// base64, identifier (valid Lua name), path (has a separator and no spaces),
+// format (string.format directive or Lua pattern class like %d) or text
+func StrClass(s string) string {
+	switch {
+	case s == "":
+		return "empty"
+	case !utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool {
+		return r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0x7f
+	}) >= 0:
+		return "binary"
+	case strClassURLRe.MatchString(s):
+		return "url"
+	case isBase64(s):
+		return "base64"
+	case strClassIdentRe.MatchString(s):
+		return "identifier"
+	case strings.ContainsAny(s, `/\`) && strClassPathRe.MatchString(s):
+		return "path"
+	case strClassFormatRe.MatchString(s):
+		return "format"
+	}
+	return "text"
+}
+
+// valid padded base64 with upper and lower case letters and digits, which
+// paths and words seldom have all of
+func isBase64(s string) bool {
+	if len(s) < strClassBase64Min || len(s)%4 != 0 || !strClassBase64Re.MatchString(s) {
+		return false
+	}
+	if !strings.ContainsAny(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") ||
+		!strings.ContainsAny(s, "abcdefghijklmnopqrstuvwxyz") ||
+		!strings.ContainsAny(s, "0123456789") {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
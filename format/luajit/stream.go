@@ -0,0 +1,242 @@
+package luajit
+
+// read dumps one proto at a time from an io.Reader, for inputs with lots of
+// concatenated dumps where parsing everything at once or the decode tree needs
+// too much memory. The decoder itself is not incremental
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/gojq"
+)
+
+func init() {
+	interp.RegisterIter0("luajit_protos", func(_ *interp.Interp, c any) gojq.Iter {
+		br, err := interp.ToBitReader(c)
+		if err != nil {
+			return gojq.NewIter(err)
+		}
+		return &protoIter{pr: NewProtoReader(bitio.NewIOReader(br), nil)}
+	})
+}
+
+// ProtoReader reads protos of dumps from r in order, concatenated dumps one
+// after another. Memory use is the largest proto and protos not yet a child
+// constant of a later proto, not the whole input
+type ProtoReader struct {
+	r       *bufio.Reader
+	opcodes BcDefList
+	// byte offset in the input
+	pos int
+
+	// dump being read, Protos is not filled in
+	dump       *Dump
+	dumpIndex  int
+	dumpOffset int
+	// stop after the first dump
+	first bool
+	stack []*Proto
+	index int
+}
+
+// NewProtoReader reads from r, opcodes is used for all dumps instead of the
+// one based on version if non-nil
+func NewProtoReader(r io.Reader, opcodes BcDefList) *ProtoReader {
+	return &ProtoReader{r: bufio.NewReader(r), opcodes: opcodes, dumpIndex: -1}
+}
+
+// n bytes, the buffer grows as they are read so that a corrupt length does
+// not allocate more than the input has
+func (pr *ProtoReader) read(n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		n = math.MaxInt64
+	}
+	buf := &bytes.Buffer{}
+	m, err := io.CopyN(buf, pr.r, int64(n))
+	pr.pos += int(m)
+	if errors.Is(err, io.EOF) {
+		return nil, errDumpShort
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// raw bytes of a ULEB128
+func (pr *ProtoReader) uleb() ([]byte, error) {
+	var bs []byte
+	for {
+		b, err := pr.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil, errDumpShort
+		} else if err != nil {
+			return nil, err
+		}
+		pr.pos++
+		bs = append(bs, b)
+		if b&0x80 == 0 {
+			return bs, nil
+		}
+	}
+}
+
+// header of the next dump, a first line starting with # is skipped before the
+// first one. false at the end of the input or if followed by other data
+func (pr *ProtoReader) header() (bool, error) {
+	if pr.dumpIndex == -1 {
+		if bs, _ := pr.r.Peek(1); bytes.Equal(bs, []byte("#")) {
+			line, err := pr.r.ReadBytes('\n')
+			if err != nil {
+				return false, errDumpShort
+			}
+			pr.pos += len(line)
+		}
+	}
+	if bs, _ := pr.r.Peek(3); !bytes.Equal(bs, []byte("\x1bLJ")) {
+		if pr.dumpIndex == -1 {
+			return false, errors.New("not a LuaJIT bytecode dump")
+		}
+		return false, nil
+	}
+	if pr.first && pr.dumpIndex == 0 {
+		return false, nil
+	}
+	pr.dumpOffset = pr.pos
+
+	// header is small, read name length and name and parse all of it
+	buf, err := pr.read(4)
+	if err != nil {
+		return false, err
+	}
+	flags, err := pr.uleb()
+	if err != nil {
+		return false, err
+	}
+	buf = append(buf, flags...)
+	if r := (&dumpReader{buf: flags}); r.uleb()&dumpFlagStrip == 0 {
+		n, err := pr.uleb()
+		if err != nil {
+			return false, err
+		}
+		buf = append(buf, n...)
+		name, err := pr.read((&dumpReader{buf: n}).uleb())
+		if err != nil {
+			return false, err
+		}
+		buf = append(buf, name...)
+	}
+	dump, err := (&dumpReader{buf: buf}).header(pr.opcodes)
+	if err != nil {
+		return false, err
+	}
+	pr.dump = dump
+	pr.dumpIndex++
+	pr.stack = nil
+	pr.index = 0
+	return true, nil
+}
+
+// Next is the next proto and the dump it is in, io.EOF after the last proto
+// of the last dump. Child constants are linked to protos returned before
+func (pr *ProtoReader) Next() (*Dump, *Proto, error) {
+	for {
+		if pr.dump == nil {
+			ok, err := pr.header()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				return nil, nil, io.EOF
+			}
+		}
+
+		offset := pr.pos - pr.dumpOffset
+		length, err := pr.uleb()
+		if err != nil {
+			return nil, nil, err
+		}
+		n := (&dumpReader{buf: length}).uleb()
+		if n == 0 {
+			// end of dump
+			pr.dump = nil
+			continue
+		}
+		body, err := pr.read(n)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r := &dumpReader{buf: append(length, body...), be: pr.dump.BigEndian()}
+		p := r.proto(pr.dump, pr.index)
+		if r.err != nil {
+			return nil, nil, fmt.Errorf("proto %d: %w", pr.index, r.err)
+		}
+		p.Offset = offset
+		p.InsOffset += offset
+		if err := linkChildren(p, &pr.stack); err != nil {
+			return nil, nil, err
+		}
+		pr.index++
+		return pr.dump, p, nil
+	}
+}
+
+// DumpIndex is the index of the dump of the last proto from Next, 0 for the
+// first dump
+func (pr *ProtoReader) DumpIndex() int { return pr.dumpIndex }
+
+// DumpOffset is the byte offset in the input of the signature of the dump of
+// the last proto from Next, proto offsets are from it
+func (pr *ProtoReader) DumpOffset() int { return pr.dumpOffset }
+
+type protoIter struct {
+	pr   *ProtoReader
+	done bool
+}
+
+// summary of each proto, children as proto indexes as the protos themselves
+// are not kept
+func (it *protoIter) Next() (any, bool) {
+	if it.done {
+		return nil, false
+	}
+	dump, p, err := it.pr.Next()
+	if err == io.EOF {
+		it.done = true
+		return nil, false
+	} else if err != nil {
+		it.done = true
+		return err, true
+	}
+	children := []any{}
+	for _, c := range p.Children() {
+		children = append(children, c.Index)
+	}
+	v := map[string]any{
+		"dump":         it.pr.DumpIndex(),
+		"index":        p.Index,
+		"offset":       it.pr.DumpOffset() + p.Offset,
+		"length":       it.pr.pos - it.pr.DumpOffset() - p.Offset,
+		"numparams":    int(p.NumParams),
+		"framesize":    int(p.FrameSize),
+		"vararg":       p.Vararg(),
+		"instructions": len(p.Ins),
+		"kgc":          len(p.KGC),
+		"knum":         len(p.KNum),
+		"uv":           len(p.UV),
+		"children":     children,
+	}
+	if !dump.Strip() {
+		v["chunk_name"] = dump.Name
+		v["firstline"] = int(p.FirstLine)
+		v["numline"] = int(p.NumLine)
+	}
+	return v, true
+}
@@ -0,0 +1,142 @@
+package luajit
+
+// find loads of globals and module fields that are often abused, ex os.execute
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_suspicious_api", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return SuspiciousAPI(dump)
+	})
+}
+
+// name to category, ffi.C is left out as every call of a C function loads it,
+// ffi.cdef declaring them is reported instead
+var suspiciousAPIs = map[string]string{
+	"os.execute":        "exec",
+	"io.popen":          "exec",
+	"os.remove":         "file",
+	"os.rename":         "file",
+	"os.tmpname":        "file",
+	"io.open":           "file",
+	"io.lines":          "file",
+	"load":              "load",
+	"loadstring":        "load",
+	"loadfile":          "load",
+	"dofile":            "load",
+	"string.dump":       "load",
+	"package.loadlib":   "native",
+	"ffi.cast":          "native",
+	"ffi.cdef":          "native",
+	"ffi.load":          "native",
+	"setfenv":           "env",
+	"getfenv":           "env",
+	"debug.getinfo":     "env",
+	"debug.setupvalue":  "env",
+	"debug.getupvalue":  "env",
+	"debug.sethook":     "env",
+	"debug.getregistry": "env",
+	"os.getenv":         "env",
+}
+
+// modules that are usually stored in a local with the same name
+var suspiciousAPIModules = map[string]bool{
+	"os":      true,
+	"io":      true,
+	"ffi":     true,
+	"package": true,
+	"debug":   true,
+	"string":  true,
+}
+
+// SuspiciousAPI finds instructions loading suspicious globals or module fields,
+// best effort by following slots linearly, modules can be globals, results of
+// require("name") or upvalues named as the module (needs debug info)
+func SuspiciousAPI(dump *Dump) []any {
+	found := []any{}
+	for _, p := range dump.Protos {
+		// slot to qualified name it was last assigned
+		names := map[int]string{}
+		// slot to string constant, for require arguments
+		strs := map[int]string{}
+
+		str := func(d int) (string, bool) {
+			k := p.KGCByD(d)
+			if k == nil || k.Type != kgcStr {
+				return "", false
+			}
+			return k.Str, true
+		}
+		report := func(pc int, name string) {
+			category, ok := suspiciousAPIs[name]
+			if !ok {
+				return
+			}
+			found = append(found, map[string]any{
+				"proto":      p.Index,
+				"proto_name": dump.ProtoName(p),
+				"pc":         pc,
+				"line":       p.Line(pc),
+				"op":         dump.OpName(p, pc),
+				"api":        name,
+				"category":   category,
+			})
+		}
+
+		for pc, ins := range p.Ins {
+			a := int(ins.A)
+			switch dump.OpName(p, pc) {
+			case "GGET":
+				if s, ok := str(int(ins.D)); ok {
+					report(pc, s)
+					names[a] = s
+					continue
+				}
+			case "TGETS":
+				if s, ok := str(int(ins.C)); ok {
+					if n, ok := names[int(ins.B)]; ok {
+						report(pc, n+"."+s)
+						names[a] = n + "." + s
+						continue
+					}
+				}
+			case "UGET":
+				if int(ins.D) < len(p.UVNames) && suspiciousAPIModules[p.UVNames[ins.D]] {
+					names[a] = p.UVNames[ins.D]
+					continue
+				}
+			case "MOV":
+				if n, ok := names[int(ins.D)]; ok {
+					names[a] = n
+					continue
+				}
+			case "KSTR":
+				if s, ok := str(int(ins.D)); ok {
+					strs[a] = s
+					continue
+				}
+			case "CALL":
+				arg := a + 1
+				if dump.FR2() {
+					arg++
+				}
+				if names[a] == "require" && suspiciousAPIModules[strs[arg]] {
+					names[a] = strs[arg]
+					delete(strs, a)
+					continue
+				}
+			}
+			if def := dump.Opcodes.Get(int(ins.Op)); def.MA == BcMdst || def.MA == BcMbase {
+				delete(names, a)
+				delete(strs, a)
+			}
+		}
+	}
+	return found
+}
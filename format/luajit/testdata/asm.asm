@@ -0,0 +1,26 @@
+; function add(a, b) return a + b end
+; local s = 0 for i = 1, 10 do s = add(s, i) end print(s)
+.proto params=2
+  ADDVV 2 0 1
+  RET1 2 2
+
+.proto
+.kchild
+  FNEW 0 0
+  KSHORT 1 0
+  KSHORT 2 1
+  KSHORT 3 10
+  KSHORT 4 1
+  FORI 2 => done
+loop:
+  MOV 6 0
+  MOV 7 1
+  MOV 8 5
+  CALL 6 2 3
+  MOV 1 6
+  FORL 2 => loop
+done:
+  GGET 2 "print"
+  MOV 3 1
+  CALL 2 1 2
+  RET0 0 1
@@ -0,0 +1,15 @@
+.proto params=1
+  KSHORT 1 1
+  ADDVV 1 1 0
+  RET1 1 2
+.proto params=2
+  ADDVV 2 0 1
+  MULVV 2 2 2
+  SUBVV 2 2 0
+  RET1 2 2
+.proto
+.kchild
+.kchild
+  FNEW 0 1
+  FNEW 1 0
+  RET0 0 1
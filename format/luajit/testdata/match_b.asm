@@ -0,0 +1,23 @@
+.proto params=2
+  ADDVV 2 0 1
+  MULVV 2 2 2
+  SUBVV 2 2 0
+  KSHORT 3 2
+  RET1 2 2
+.proto params=1
+  KSHORT 1 2
+  ADDVV 1 1 0
+  RET1 1 2
+.proto params=1
+  UNM 1 0
+  ISTC 1 0
+  JMP 1 1
+  RET1 1 2
+.proto
+.kchild
+.kchild
+.kchild
+  FNEW 0 2
+  FNEW 1 1
+  FNEW 2 0
+  RET0 0 1
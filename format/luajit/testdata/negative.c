@@ -0,0 +1,11 @@
+#ifdef __cplusplus
+extern "C"
+#endif
+#ifdef _WIN32
+__declspec(dllexport)
+#endif
+const unsigned char luaJIT_BC_negative[] = {
+27,76,74,2,10,20,0,1,2,0,0,1,2,24,1,0,0,76,1,2,0,174,134,149,253,31,25,0,1,2,
+0,0,1,2,24,1,0,0,76,1,2,0,129,128,144,157,12,138,161,136,145,12,35,3,0,1,0,4,
+0,5,51,0,0,0,55,0,1,0,51,0,2,0,55,0,3,0,75,0,1,0,7,102,50,0,7,102,49,0,0
+};
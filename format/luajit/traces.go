@@ -0,0 +1,179 @@
+package luajit
+
+// JIT trace events from luajit -jv or -jdump logs by instruction
+
+import (
+	"bufio"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc1("luajit_traces", func(_ *interp.Interp, c any, log string) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Traces(dump, log)
+	})
+}
+
+var (
+	// [TRACE   3 (1/2) example.lua:27 loop]
+	traceJVRe = regexp.MustCompile(`^\[TRACE\s+(\d+)\s+(?:\(\d+/\d+\)\s+)?(\S+):(\d+)`)
+	// [TRACE --- (1/0) example.lua:27 -- NYI: bytecode 51 at example.lua:28]
+	traceJVAbortRe = regexp.MustCompile(`^\[TRACE\s+---\s+(?:\(\d+/\d+\)\s+)?(\S+):(\d+)\s+--\s+(.*?)(?:\s+at\s+(\S+):(\d+))?\]$`)
+	// ---- TRACE 3 start 1/2 example.lua:27
+	traceDumpStartRe = regexp.MustCompile(`^---- TRACE (\d+) start (?:\d+/\d+ )?(\S+):(\d+)`)
+	// ---- TRACE 3 stop -> loop
+	traceDumpStopRe = regexp.MustCompile(`^---- TRACE (\d+) stop`)
+	// ---- TRACE 3 abort example.lua:28 -- NYI: bytecode 51
+	traceDumpAbortRe = regexp.MustCompile(`^---- TRACE (\d+) abort (\S+):(\d+) -- (.*)$`)
+)
+
+type traceLoc struct {
+	chunk string
+	line  int
+}
+
+type traceEvents struct {
+	// recording started here, the instruction got hot
+	hot    int
+	traces []any
+	aborts int
+	// unique in log order
+	reasons []any
+}
+
+func (e *traceEvents) abort(reason string) {
+	e.aborts++
+	for _, r := range e.reasons {
+		if r == reason {
+			return
+		}
+	}
+	e.reasons = append(e.reasons, reason)
+}
+
+// chunk name as LuaJIT shows it in logs matches the dump chunk name, long
+// file names are shortened to "..." and the end of the name
+func (dump *Dump) traceChunk(name string) bool {
+	short := dump.Name
+	if strings.HasPrefix(short, "@") || strings.HasPrefix(short, "=") {
+		short = short[1:]
+	}
+	if strings.HasPrefix(name, "...") {
+		return strings.HasSuffix(short, name[3:])
+	}
+	return name == short
+}
+
+// parse -jv and -jdump lines, other lines are ignored
+func parseTraceLog(log string) map[traceLoc]*traceEvents {
+	events := map[traceLoc]*traceEvents{}
+	at := func(chunk string, line string) *traceEvents {
+		n, _ := strconv.Atoi(line)
+		l := traceLoc{chunk: chunk, line: n}
+		e, ok := events[l]
+		if !ok {
+			e = &traceEvents{traces: []any{}, reasons: []any{}}
+			events[l] = e
+		}
+		return e
+	}
+	// -jdump trace number to start location
+	started := map[string]*traceEvents{}
+
+	s := bufio.NewScanner(strings.NewReader(log))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if m := traceJVAbortRe.FindStringSubmatch(l); m != nil {
+			at(m[1], m[2]).hot++
+			if m[4] != "" {
+				at(m[4], m[5]).abort(m[3])
+			} else {
+				at(m[1], m[2]).abort(m[3])
+			}
+		} else if m := traceJVRe.FindStringSubmatch(l); m != nil {
+			e := at(m[2], m[3])
+			e.hot++
+			n, _ := strconv.Atoi(m[1])
+			e.traces = append(e.traces, n)
+		} else if m := traceDumpStartRe.FindStringSubmatch(l); m != nil {
+			e := at(m[2], m[3])
+			e.hot++
+			started[m[1]] = e
+		} else if m := traceDumpStopRe.FindStringSubmatch(l); m != nil {
+			if e, ok := started[m[1]]; ok {
+				n, _ := strconv.Atoi(m[1])
+				e.traces = append(e.traces, n)
+				delete(started, m[1])
+			}
+		} else if m := traceDumpAbortRe.FindStringSubmatch(l); m != nil {
+			at(m[2], m[3]).abort(m[4])
+			delete(started, m[1])
+		}
+	}
+	return events
+}
+
+// Traces maps trace starts and aborts in a -jv or -jdump log to the first
+// instruction of the line in each proto, hot is number of times recording
+// started, traces are the numbers of completed traces. Needs debug info as
+// logs only have chunk name and line
+func Traces(dump *Dump, log string) any {
+	if dump.Strip() {
+		return errors.New("stripped dump has no line info")
+	}
+	events := parseTraceLog(log)
+
+	type insEvents struct {
+		p  *Proto
+		pc int
+		e  *traceEvents
+	}
+	var found []insEvents
+	for l, e := range events {
+		if !dump.traceChunk(l.chunk) {
+			continue
+		}
+		for _, p := range dump.Protos {
+			if len(p.LineInfo) != len(p.Ins) {
+				continue
+			}
+			for pc := range p.Ins {
+				if int(p.Line(pc)) == l.line {
+					found = append(found, insEvents{p: p, pc: pc, e: e})
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].p.Index != found[j].p.Index {
+			return found[i].p.Index < found[j].p.Index
+		}
+		return found[i].pc < found[j].pc
+	})
+
+	instructions := []any{}
+	for _, f := range found {
+		instructions = append(instructions, map[string]any{
+			"proto":         f.p.Index,
+			"proto_name":    dump.ProtoName(f.p),
+			"pc":            f.pc,
+			"line":          int(f.p.Line(f.pc)),
+			"op":            dump.OpName(f.p, f.pc),
+			"hot":           f.e.hot,
+			"traces":        f.e.traces,
+			"aborts":        f.e.aborts,
+			"abort_reasons": f.e.reasons,
+		})
+	}
+	return instructions
+}
@@ -0,0 +1,292 @@
+package luajit
+
+// transforms that modify a dump and encode it again as binary
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type normalizeOpts struct {
+	Strip bool
+}
+
+type patchOpts struct {
+	Proto int
+	PC    int
+	Ins   map[string]any
+}
+
+func init() {
+	interp.RegisterFunc1("_luajit_patch", func(_ *interp.Interp, c any, opts patchOpts) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		if err := dump.Patch(opts.Proto, opts.PC, opts.Ins); err != nil {
+			return err
+		}
+		return toBinary(dump.Encode())
+	})
+	interp.RegisterFunc1("luajit_rename", func(_ *interp.Interp, c any, name string) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		if err := dump.Rename(name); err != nil {
+			return err
+		}
+		return toBinary(dump.Encode())
+	})
+	interp.RegisterFunc1("_luajit_normalize", func(_ *interp.Interp, c any, opts normalizeOpts) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		dump.Normalize(opts.Strip)
+		return toBinary(dump.Encode())
+	})
+	interp.RegisterFunc1("luajit_extract_proto", func(_ *interp.Interp, c any, index int) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		if err := dump.ExtractProto(index); err != nil {
+			return err
+		}
+		return toBinary(dump.Encode())
+	})
+	interp.RegisterFunc2("luajit_replace_string", func(_ *interp.Interp, c any, old string, repl string) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		if dump.ReplaceString(old, repl) == 0 {
+			return fmt.Errorf("string constant %q not found", old)
+		}
+		return toBinary(dump.Encode())
+	})
+}
+
+// jq number as int
+func toInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), float64(int(v)) == v
+	default:
+		return 0, false
+	}
+}
+
+// instruction from an object like {op: "KSHORT", a: 0, d: 1}, op is name or
+// number and operands not set are zero, d is signed for jumps and lits
+func (dump *Dump) insFromObject(obj map[string]any) (Ins, error) {
+	var ins Ins
+	var def *BcDef
+	switch op := obj["op"].(type) {
+	case string:
+		found := false
+		for i := range dump.Opcodes {
+			if dump.Opcodes[i].Name == op {
+				ins.Op = uint8(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ins, fmt.Errorf("unknown op %q", op)
+		}
+	default:
+		n, ok := toInt(op)
+		if !ok || n < 0 || n > 0xff {
+			return ins, fmt.Errorf("op must be a name or number 0-255")
+		}
+		ins.Op = uint8(n)
+	}
+	def = dump.Opcodes.Get(int(ins.Op))
+
+	operand := func(name string, min int, max int) (int, error) {
+		v, ok := obj[name]
+		if !ok {
+			return 0, nil
+		}
+		n, ok := toInt(v)
+		if !ok || n < min || n > max {
+			return 0, fmt.Errorf("%s must be a number %d-%d", name, min, max)
+		}
+		return n, nil
+	}
+
+	a, err := operand("a", 0, 0xff)
+	if err != nil {
+		return ins, err
+	}
+	ins.A = uint8(a)
+
+	if !def.HasD() {
+		b, err := operand("b", 0, 0xff)
+		if err != nil {
+			return ins, err
+		}
+		c, err := operand("c", 0, 0xff)
+		if err != nil {
+			return ins, err
+		}
+		ins.B = uint8(b)
+		ins.C = uint8(c)
+		ins.D = uint16(b)<<8 | uint16(c)
+		return ins, nil
+	}
+
+	var d int
+	switch {
+	case def.IsJump():
+		d, err = operand("d", -0x8000, 0x7fff)
+		d += 0x8000
+	case def.IsLits():
+		d, err = operand("d", -0x8000, 0x7fff)
+	default:
+		d, err = operand("d", 0, 0xffff)
+	}
+	if err != nil {
+		return ins, err
+	}
+	ins.D = uint16(d)
+	ins.B = uint8(d >> 8)
+	ins.C = uint8(d)
+	return ins, nil
+}
+
+// Patch replaces instruction index pc in proto index (same as .proto[index])
+func (dump *Dump) Patch(index int, pc int, obj map[string]any) error {
+	if index < 0 || index >= len(dump.Protos) {
+		return fmt.Errorf("proto %d out of range", index)
+	}
+	p := dump.Protos[index]
+	if pc < 0 || pc >= len(p.Ins) {
+		return fmt.Errorf("pc %d out of range", pc)
+	}
+	ins, err := dump.insFromObject(obj)
+	if err != nil {
+		return err
+	}
+	p.Ins[pc] = ins
+	return nil
+}
+
+// Rename sets the chunk name, including "@" or "=" prefix if wanted, stripped
+// dumps have no name
+func (dump *Dump) Rename(name string) error {
+	if dump.Strip() {
+		return fmt.Errorf("stripped dump has no chunk name")
+	}
+	dump.Name = name
+	return nil
+}
+
+// ReplaceString replaces string constants, also keys and values in table
+// constants, equal to old with repl and returns number of replaced
+func (dump *Dump) ReplaceString(old string, repl string) int {
+	n := 0
+	ktabk := func(k *KTabK) {
+		if k.Type == ktabStr && k.Str == old {
+			k.Str = repl
+			n++
+		}
+	}
+	for _, p := range dump.Protos {
+		for i := range p.KGC {
+			k := &p.KGC[i]
+			switch k.Type {
+			case kgcStr:
+				if k.Str == old {
+					k.Str = repl
+					n++
+				}
+			case kgcTab:
+				for j := range k.Tab.Array {
+					ktabk(&k.Tab.Array[j])
+				}
+				for j := range k.Tab.Hash {
+					ktabk(&k.Tab.Hash[j][0])
+					ktabk(&k.Tab.Hash[j][1])
+				}
+			}
+		}
+	}
+	return n
+}
+
+// ExtractProto keeps only proto index (same as .proto[index]) and its children
+// with it as the main chunk, header is kept. Upvalues of the proto are nil when
+// loaded as there is no parent
+func (dump *Dump) ExtractProto(index int) error {
+	if index < 0 || index >= len(dump.Protos) {
+		return fmt.Errorf("proto %d out of range", index)
+	}
+	root := dump.Protos[index]
+	inTree := func(p *Proto) bool {
+		for ; p != nil; p = p.Parent {
+			if p == root {
+				return true
+			}
+		}
+		return false
+	}
+	// children are before their parent so root is last
+	var protos []*Proto
+	for _, p := range dump.Protos[:index+1] {
+		if inTree(p) {
+			p.Index = len(protos)
+			protos = append(protos, p)
+		}
+	}
+	root.Parent = nil
+	dump.Protos = protos
+	return nil
+}
+
+// Normalize makes the dump little endian, stripped if strip is set, and sorts
+// table constant hash parts by key. Encode writes ULEB128 with as few bytes as
+// possible so same functions encode to the same bytes
+func (dump *Dump) Normalize(strip bool) {
+	be := dump.BigEndian()
+	dump.Flags &^= dumpFlagBE
+	if strip {
+		dump.Flags |= dumpFlagStrip
+		dump.Name = ""
+	}
+
+	for _, p := range dump.Protos {
+		switch {
+		case dump.Strip():
+			p.Debug = nil
+			p.FirstLine = 0
+			p.NumLine = 0
+		case be:
+			// line info entries are in dump byte order, the rest is bytes and ULEB128
+			p.Debug = append([]byte{}, p.Debug...)
+			w := p.lineWidth()
+			for i := 0; i < len(p.Ins) && (i+1)*w <= len(p.Debug); i++ {
+				e := p.Debug[i*w : (i+1)*w]
+				for j := 0; j < w/2; j++ {
+					e[j], e[w-1-j] = e[w-1-j], e[j]
+				}
+			}
+		}
+
+		for _, k := range p.KGC {
+			if k.Type != kgcTab {
+				continue
+			}
+			key := func(k KTabK) string { return fmt.Sprintf("%d:%v", k.Type, k.value()) }
+			sort.SliceStable(k.Tab.Hash, func(i, j int) bool {
+				return key(k.Tab.Hash[i][0]) < key(k.Tab.Hash[j][0])
+			})
+		}
+	}
+}
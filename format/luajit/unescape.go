@@ -0,0 +1,114 @@
+package luajit
+
+// lua string literal escapes back to bytes, used by loaders that embed
+// bytecode as "\27LJ\2..." strings
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_unescape", func(_ *interp.Interp, c any) any {
+		s, ok := c.(string)
+		if !ok {
+			return fmt.Errorf("expected string")
+		}
+		buf, err := LuaUnescape(s)
+		if err != nil {
+			return err
+		}
+		return toBinary(buf)
+	})
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// LuaUnescape decodes escapes like lua does for string literals, \ddd,
+// \xNN, \u{XXX}, \z and the single character ones. Surrounding quotes are
+// removed if present
+func LuaUnescape(s string) ([]byte, error) {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf = append(buf, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("unfinished escape at end")
+		}
+		switch c := s[i]; c {
+		case 'a':
+			buf = append(buf, '\a')
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n', '\n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'v':
+			buf = append(buf, '\v')
+		case '\\', '"', '\'':
+			buf = append(buf, c)
+		case 'z':
+			for i+1 < len(s) && isSpace(s[i+1]) {
+				i++
+			}
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("invalid \\x escape at %d", i-1)
+			}
+			n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid \\x escape at %d", i-1)
+			}
+			buf = append(buf, byte(n))
+			i += 2
+		case 'u':
+			end := i + 2
+			for end < len(s) && s[end] != '}' {
+				end++
+			}
+			if i+1 >= len(s) || s[i+1] != '{' || end >= len(s) {
+				return nil, fmt.Errorf("invalid \\u escape at %d", i-1)
+			}
+			n, err := strconv.ParseUint(s[i+2:end], 16, 32)
+			if err != nil || n > utf8.MaxRune {
+				return nil, fmt.Errorf("invalid \\u escape at %d", i-1)
+			}
+			buf = utf8.AppendRune(buf, rune(n))
+			i = end
+		default:
+			if !isDigit(c) {
+				return nil, fmt.Errorf("invalid escape \\%c at %d", c, i-1)
+			}
+			n := 0
+			j := i
+			for ; j < len(s) && j < i+3 && isDigit(s[j]); j++ {
+				n = n*10 + int(s[j]-'0')
+			}
+			if n > 255 {
+				return nil, fmt.Errorf("decimal escape too large at %d", i-1)
+			}
+			buf = append(buf, byte(n))
+			i = j - 1
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,71 @@
+package luajit
+
+// constants not referenced by any instruction in their proto
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_unused_constants", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return UnusedConstants(dump)
+	})
+}
+
+// UnusedConstants lists kgc and knum entries that no operand references,
+// kgc index is dump order as .pdata.kgc[index]
+func UnusedConstants(dump *Dump) []any {
+	unused := []any{}
+	for _, p := range dump.Protos {
+		kgcUsed := map[int]bool{}
+		knumUsed := map[int]bool{}
+		for _, ins := range p.Ins {
+			def := dump.Opcodes.Get(int(ins.Op))
+			operand := int(ins.D)
+			if !def.HasD() {
+				operand = int(ins.C)
+			}
+			switch def.MC {
+			case BcMstr, BcMtab, BcMfunc, BcMcdata:
+				kgcUsed[operand] = true
+			case BcMnum:
+				knumUsed[operand] = true
+			}
+		}
+
+		for i, k := range p.KGC {
+			runtimeIndex := len(p.KGC) - 1 - i
+			if kgcUsed[runtimeIndex] {
+				continue
+			}
+			unused = append(unused, map[string]any{
+				"proto":         p.Index,
+				"kind":          "kgc",
+				"index":         i,
+				"runtime_index": runtimeIndex,
+				"type":          kgcKinds[k.Type],
+				"value":         dump.kgcRepr(k),
+			})
+		}
+		for i, k := range p.KNum {
+			if knumUsed[i] {
+				continue
+			}
+			var v any = k.Num
+			if k.IsInt {
+				v = int(k.Int)
+			}
+			unused = append(unused, map[string]any{
+				"proto": p.Index,
+				"kind":  "knum",
+				"index": i,
+				"value": v,
+			})
+		}
+	}
+	return unused
+}
@@ -0,0 +1,111 @@
+package luajit
+
+// what outer local each upvalue captures, through upvalues of enclosing protos
+
+import (
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc0("luajit_upvalues", func(_ *interp.Interp, c any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Upvalues(dump)
+	})
+}
+
+// uvdata entries are a slot in the parent if local, otherwise an upvalue index
+// in the parent, immutable if the local is never assigned after capture
+const (
+	uvFlagLocal     = 0x8000
+	uvFlagImmutable = 0x4000
+)
+
+func uvIndex(uv uint16) int {
+	if uv&uvFlagLocal != 0 {
+		return int(uv & 0xff)
+	}
+	return int(uv &^ uvFlagImmutable)
+}
+
+// instruction index of the FNEW in the parent creating p, -1 if none
+func fnewPC(dump *Dump, p *Proto) int {
+	if p.Parent == nil {
+		return -1
+	}
+	for pc, ins := range p.Parent.Ins {
+		if dump.OpName(p.Parent, pc) != "FNEW" {
+			continue
+		}
+		if k := p.Parent.KGCByD(int(ins.D)); k != nil && k.Child == p {
+			return pc
+		}
+	}
+	return -1
+}
+
+// UpvalueChain is the upvalues of outer protos followed until the local, last
+// step is "unresolved" if there is no parent or an index is out of range
+func (dump *Dump) UpvalueChain(p *Proto, i int) []any {
+	path := []any{}
+	for cur, uv := p, p.UV[i]; ; {
+		parent := cur.Parent
+		if parent == nil {
+			path = append(path, map[string]any{"kind": "unresolved"})
+			return path
+		}
+		step := map[string]any{
+			"proto":      parent.Index,
+			"proto_name": dump.ProtoName(parent),
+		}
+		path = append(path, step)
+
+		if uv&uvFlagLocal != 0 {
+			slot := uvIndex(uv)
+			step["kind"] = "local"
+			step["slot"] = slot
+			if pc := fnewPC(dump, cur); pc >= 0 {
+				step["pc"] = pc
+				if n := parent.VarName(pc, slot); n != "" {
+					step["name"] = n
+				}
+			}
+			return path
+		}
+
+		index := uvIndex(uv)
+		step["kind"] = "upvalue"
+		step["index"] = index
+		if n := parent.uvName(index); n != "" {
+			step["name"] = n
+		}
+		if index >= len(parent.UV) {
+			path = append(path, map[string]any{"kind": "unresolved"})
+			return path
+		}
+		cur, uv = parent, parent.UV[index]
+	}
+}
+
+// Upvalues lists upvalues of all protos with the chain to the captured local
+func Upvalues(dump *Dump) []any {
+	uvs := []any{}
+	for _, p := range dump.Protos {
+		for i, uv := range p.UV {
+			u := map[string]any{
+				"proto":      p.Index,
+				"proto_name": dump.ProtoName(p),
+				"index":      i,
+				"immutable":  uv&uvFlagImmutable != 0,
+				"path":       dump.UpvalueChain(p, i),
+			}
+			if n := p.uvName(i); n != "" {
+				u["name"] = n
+			}
+			uvs = append(uvs, u)
+		}
+	}
+	return uvs
+}
@@ -0,0 +1,113 @@
+package luajit
+
+// parameters, locals and upvalues of each proto in one place, names and pc
+// ranges are in the debug info and upvalue targets in uvdata
+
+import (
+	"github.com/wader/fq/pkg/decode"
+)
+
+// Variable is a parameter or local, pcs are as in the debug info where 0 is
+// the function header, so instruction index + 1
+type Variable struct {
+	Name    string
+	Slot    int
+	StartPC uint64
+	EndPC   uint64
+}
+
+// UpvalueVar is an upvalue, Parent is a slot in the parent if Local,
+// otherwise an upvalue index in the parent
+type UpvalueVar struct {
+	Name      string
+	Local     bool
+	Immutable bool
+	Parent    int
+}
+
+// ProtoVariables is the params, locals and upvalues of p. The slot of a local
+// is the number of variables still live where it starts, like LuaJIT assigns
+// them
+func ProtoVariables(p *Proto) (params []Variable, locals []Variable, upvalues []UpvalueVar) {
+	for i, v := range p.VarInfo {
+		slot := 0
+		for _, o := range p.VarInfo[:i] {
+			if o.EndPC > v.StartPC {
+				slot++
+			}
+		}
+		name := v.Name
+		if v.Type != 0 {
+			name = varInfoInternalNames[v.Type]
+		}
+		e := Variable{Name: name, Slot: slot, StartPC: v.StartPC, EndPC: v.EndPC}
+		if v.StartPC == 0 && slot < int(p.NumParams) {
+			params = append(params, e)
+		} else {
+			locals = append(locals, e)
+		}
+	}
+	for i, uv := range p.UV {
+		e := UpvalueVar{
+			Local:     uv&uvFlagLocal != 0,
+			Immutable: uv&uvFlagImmutable != 0,
+			Parent:    uvIndex(uv),
+		}
+		if i < len(p.UVNames) {
+			e.Name = p.UVNames[i]
+		}
+		upvalues = append(upvalues, e)
+	}
+	return params, locals, upvalues
+}
+
+func decodeVariables(d *decode.D, name string, vars []Variable) {
+	d.FieldArray(name, func(d *decode.D) {
+		for _, v := range vars {
+			d.FieldStruct("variable", func(d *decode.D) {
+				d.FieldValueStr("name", v.Name)
+				d.FieldValueUint("slot", uint64(v.Slot))
+				d.FieldValueUint("start_pc", v.StartPC)
+				d.FieldValueUint("end_pc", v.EndPC)
+			})
+		}
+	})
+}
+
+// variables is from the dump parsed again, names are only in the debug info so
+// it is not added for stripped dumps
+func LuaJITDecodeVariables(di *DumpInfo, d *decode.D, start int64) {
+	if di.Strip || di.Opts.NoHeader || di.Opts.HeadersOnly {
+		return
+	}
+	dump, err := ParseDump(d.BytesRange(start, int((d.Pos()-start)/8)), di.Opcodes)
+	if err != nil {
+		return
+	}
+	d.FieldArray("variables", func(d *decode.D) {
+		for _, p := range dump.Protos {
+			params, locals, upvalues := ProtoVariables(p)
+			d.FieldStruct("proto", func(d *decode.D) {
+				d.FieldValueUint("index", uint64(p.Index))
+				d.FieldValueStr("name", dump.ProtoName(p))
+				decodeVariables(d, "params", params)
+				decodeVariables(d, "locals", locals)
+				d.FieldArray("upvalues", func(d *decode.D) {
+					for i, uv := range upvalues {
+						d.FieldStruct("upvalue", func(d *decode.D) {
+							d.FieldValueUint("index", uint64(i))
+							d.FieldValueStr("name", uv.Name)
+							d.FieldValueBool("local", uv.Local)
+							d.FieldValueBool("immutable", uv.Immutable)
+							if uv.Local {
+								d.FieldValueUint("parent_slot", uint64(uv.Parent))
+							} else {
+								d.FieldValueUint("parent_upvalue", uint64(uv.Parent))
+							}
+						})
+					}
+				})
+			})
+		}
+	})
+}
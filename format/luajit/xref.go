@@ -0,0 +1,114 @@
+package luajit
+
+// instructions using a string or number constant
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFunc1("luajit_xref", func(_ *interp.Interp, c any, v any) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		return Xref(dump, v)
+	})
+}
+
+// constant matches a string or a number, ints and floats with the same value
+// are equal like in Lua
+func xrefMatch(v any, str string, isStr bool, num float64) bool {
+	switch v := v.(type) {
+	case string:
+		return isStr && v == str
+	case int:
+		return !isStr && float64(v) == num
+	case float64:
+		return !isStr && v == num
+	default:
+		return false
+	}
+}
+
+func (k KTabK) xrefMatch(v any) bool {
+	switch k.Type {
+	case ktabInt:
+		return xrefMatch(v, "", false, float64(k.Int))
+	case ktabNum:
+		return xrefMatch(v, "", false, k.Num)
+	case ktabStr:
+		return xrefMatch(v, k.Str, true, 0)
+	default:
+		return false
+	}
+}
+
+// Xref finds instructions with a string or number constant operand equal to
+// v, ex GGET, TGETS and ISEQS for strings and KNUM, KSHORT, ISEQN and
+// arithmetic for numbers. TDUP matches if a key or value in the table
+// constant is equal
+func Xref(dump *Dump, v any) any {
+	switch v.(type) {
+	case string, int, float64:
+	default:
+		return fmt.Errorf("value must be a string or a number")
+	}
+
+	refs := []any{}
+	for _, p := range dump.Protos {
+		for pc, ins := range p.Ins {
+			def := dump.Opcodes.Get(int(ins.Op))
+			operand := int(ins.D)
+			if !def.HasD() {
+				operand = int(ins.C)
+			}
+
+			match := false
+			switch def.MC {
+			case BcMstr:
+				if k := p.KGCByD(operand); k != nil && k.Type == kgcStr {
+					match = xrefMatch(v, k.Str, true, 0)
+				}
+			case BcMnum:
+				// TSETM operand is a start index with a bias, not a value
+				if k := p.KNumByD(operand); k != nil && def.Name != "TSETM" {
+					if k.IsInt {
+						match = xrefMatch(v, "", false, float64(k.Int))
+					} else {
+						match = xrefMatch(v, "", false, k.Num)
+					}
+				}
+			case BcMlits:
+				match = xrefMatch(v, "", false, float64(int16(ins.D)))
+			case BcMtab:
+				if k := p.KGCByD(operand); k != nil && k.Type == kgcTab {
+					for _, e := range k.Tab.Array {
+						match = match || e.xrefMatch(v)
+					}
+					for _, kv := range k.Tab.Hash {
+						match = match || kv[0].xrefMatch(v) || kv[1].xrefMatch(v)
+					}
+				}
+			}
+			if !match {
+				continue
+			}
+
+			r := map[string]any{
+				"proto":       p.Index,
+				"proto_name":  dump.ProtoName(p),
+				"pc":          pc,
+				"op":          def.Name,
+				"instruction": dump.InsString(p, pc),
+			}
+			if len(p.LineInfo) == len(p.Ins) {
+				r["line"] = int(p.Line(pc))
+			}
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,113 @@
+package luajit
+
+// YARA rule matching the instructions of protos, register operands are
+// wildcards so that the rule still matches if slots are allocated differently
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wader/fq/pkg/interp"
+)
+
+type yaraOpts struct {
+	Name   string
+	Protos []int
+}
+
+func init() {
+	interp.RegisterFunc1("_luajit_yara", func(_ *interp.Interp, c any, opts yaraOpts) any {
+		dump, err := toDump(c)
+		if err != nil {
+			return err
+		}
+		s, err := YARARule(dump, opts.Name, opts.Protos)
+		if err != nil {
+			return err
+		}
+		return s
+	})
+}
+
+var yaraIdentRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func isRegisterMode(mode int) bool {
+	switch mode {
+	case BcMdst, BcMbase, BcMvar, BcMrbase:
+		return true
+	}
+	return false
+}
+
+// instruction bytes in dump order as hex, register operands as ??
+func (dump *Dump) yaraHex(ins Ins) string {
+	def := dump.Opcodes.Get(int(ins.Op))
+	hex := func(b uint8, wild bool) string {
+		if wild {
+			return "??"
+		}
+		return fmt.Sprintf("%02x", b)
+	}
+	// op a c b or op a d as in memory, little endian
+	bs := []string{hex(ins.Op, false), hex(ins.A, isRegisterMode(def.MA))}
+	if def.HasD() {
+		wild := isRegisterMode(def.MC)
+		bs = append(bs, hex(uint8(ins.D), wild), hex(uint8(ins.D>>8), wild))
+	} else {
+		bs = append(bs, hex(ins.C, isRegisterMode(def.MC)), hex(ins.B, isRegisterMode(def.MB)))
+	}
+	if dump.BigEndian() {
+		bs[0], bs[1], bs[2], bs[3] = bs[3], bs[2], bs[1], bs[0]
+	}
+	return strings.Join(bs, " ")
+}
+
+// YARARule is a rule named name with a hex string per proto index in protos,
+// all protos if empty, and a condition that all match. Each instruction is on
+// its own line with a luajit -bl style comment
+func YARARule(dump *Dump, name string, protos []int) (string, error) {
+	if name == "" {
+		name = "luajit_dump"
+		if mod, ok := cModName(strings.TrimPrefix(dump.Name, "@")); ok && strings.HasPrefix(dump.Name, "@") {
+			name = "luajit_" + mod
+		}
+	}
+	name = yaraIdentRe.ReplaceAllString(name, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	selected := dump.Protos
+	if len(protos) > 0 {
+		selected = nil
+		for _, i := range protos {
+			if i < 0 || i >= len(dump.Protos) {
+				return "", fmt.Errorf("proto %d not found, dump has %d protos", i, len(dump.Protos))
+			}
+			selected = append(selected, dump.Protos[i])
+		}
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "rule %s\n{\n", name)
+	sb.WriteString("    meta:\n")
+	desc := "LuaJIT protos"
+	if !dump.Strip() {
+		desc += " from " + dump.Name
+	}
+	fmt.Fprintf(sb, "        description = %q\n", desc)
+	sb.WriteString("    strings:\n")
+	for _, p := range selected {
+		fmt.Fprintf(sb, "        // proto %d %s\n", p.Index, dump.ProtoName(p))
+		fmt.Fprintf(sb, "        $proto_%d = {\n", p.Index)
+		for pc, ins := range p.Ins {
+			fmt.Fprintf(sb, "            %s // %s\n", dump.yaraHex(ins), strings.TrimSpace(dump.bcline(p, pc, false)))
+		}
+		sb.WriteString("        }\n")
+	}
+	sb.WriteString("    condition:\n")
+	sb.WriteString("        all of them\n")
+	sb.WriteString("}\n")
+	return sb.String(), nil
+}